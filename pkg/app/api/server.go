@@ -2,9 +2,12 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
@@ -15,6 +18,9 @@ import (
 	"github.com/srvc/ery/pkg/util/echoutil"
 )
 
+// eventKeepAliveInterval is an interval of comments sent to event streams to keep idle connections.
+var eventKeepAliveInterval = 15 * time.Second
+
 // Server is an interface of API server.
 type Server interface {
 	Serve(context.Context) error
@@ -29,15 +35,26 @@ type Config struct {
 type server struct {
 	*Config
 	mappingRepo domain.MappingRepository
+	statusRepo  domain.StatusRepository
+	certRepo    domain.CertificateRepository
 	server      *http.Server
+	closing     chan struct{} // closed on shutdown to finish event streams
 	log         *zap.Logger
 }
 
 // NewServer creates an API server instance.
-func NewServer(mappingRepo domain.MappingRepository, cfg *Config) Server {
+func NewServer(
+	mappingRepo domain.MappingRepository,
+	statusRepo domain.StatusRepository,
+	certRepo domain.CertificateRepository,
+	cfg *Config,
+) Server {
 	return &server{
 		Config:      cfg,
 		mappingRepo: mappingRepo,
+		statusRepo:  statusRepo,
+		certRepo:    certRepo,
+		closing:     make(chan struct{}),
 		log:         zap.L().Named("api"),
 	}
 }
@@ -57,6 +74,7 @@ func (s *server) Serve(ctx context.Context) error {
 	s.server = &http.Server{
 		Handler: s.createHandler(),
 	}
+	s.server.RegisterOnShutdown(func() { close(s.closing) })
 
 	errCh := make(chan error, 1)
 	go func() {
@@ -88,21 +106,36 @@ func (s *server) createHandler() http.Handler {
 	e.Use(echoutil.ZapLoggerMiddleware(s.log))
 
 	e.GET("/mappings", s.handleGetMappings)
+	e.GET("/mappings/events", s.handleGetMappingEvents)
+	e.GET("/mappings/:host", s.handleGetMapping)
 	e.POST("/mappings", s.handlePostMappings)
 	e.DELETE("/mappings/:host", s.handleDeleteMappings)
+	e.POST("/mappings/:host/aliases", s.handlePostAliases)
+	e.PUT("/mappings/:host/lease", s.handlePutLease)
+	e.PUT("/mappings/:host/status", s.handlePutStatus)
+	e.PUT("/mappings/:host/routes", s.handlePutRoutes)
+	e.DELETE("/mappings/:host/replicas/:id", s.handleDeleteReplica)
+	e.GET("/lookup/:host", s.handleGetLookup)
+	e.GET("/reverse/:ip", s.handleGetReverse)
+	e.GET("/map", s.handleGetMap)
+	e.GET("/status", s.handleGetStatus)
+	e.GET("/certificates/ca", s.handleGetCACertificate)
 
 	return e
 }
 
 func (s *server) handlePostMappings(c echo.Context) error {
-	var req domain.Addr
+	var req struct {
+		domain.Addr
+		domain.CreateOptions
+	}
 
 	if err := c.Bind(&req); err != nil {
 		s.err(c, http.StatusBadRequest, err)
 		return errors.WithStack(err)
 	}
 
-	resp, err := s.mappingRepo.Create(c.Request().Context(), req, 0)
+	resp, err := s.mappingRepo.Create(c.Request().Context(), req.Addr, 0, domain.WithCreateOptions(req.CreateOptions))
 	if err != nil {
 		s.err(c, http.StatusInternalServerError, err)
 		return errors.WithStack(err)
@@ -130,6 +163,270 @@ func (s *server) handleGetMappings(c echo.Context) error {
 	return nil
 }
 
+// handleGetMappingEvents streams mapping events as server-sent events.
+func (s *server) handleGetMappingEvents(c echo.Context) error {
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	evCh, errCh := s.mappingRepo.ListenEvent(ctx)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	ticker := time.NewTicker(eventKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev := <-evCh:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", ev.Type, data)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		case <-ticker.C:
+			_, err := fmt.Fprint(res, ": keepalive\n\n")
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		case err := <-errCh:
+			if errors.Cause(err) == context.Canceled {
+				return nil
+			}
+			return errors.WithStack(err)
+		case <-ctx.Done():
+			return nil
+		case <-s.closing:
+			return nil
+		}
+		res.Flush()
+	}
+}
+
+func (s *server) handleGetMapping(c echo.Context) error {
+	host := c.Param("host")
+
+	resp, ok := s.mappingRepo.Get(c.Request().Context(), host)
+	if !ok {
+		err := errors.Errorf("%s is not found", host)
+		s.err(c, http.StatusNotFound, err)
+		return errors.WithStack(err)
+	}
+
+	c.JSON(http.StatusOK, resp)
+
+	return nil
+}
+
+func (s *server) handlePostAliases(c echo.Context) error {
+	var req struct {
+		Alias string `json:"alias"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		s.err(c, http.StatusBadRequest, err)
+		return errors.WithStack(err)
+	}
+
+	host := c.Param("host")
+
+	if _, ok := s.mappingRepo.Get(c.Request().Context(), host); !ok {
+		err := errors.Errorf("%s is not found", host)
+		s.err(c, http.StatusNotFound, err)
+		return errors.WithStack(err)
+	}
+
+	err := s.mappingRepo.AddAlias(c.Request().Context(), host, req.Alias)
+	if err != nil {
+		s.err(c, http.StatusUnprocessableEntity, err)
+		return errors.WithStack(err)
+	}
+
+	c.NoContent(http.StatusCreated)
+
+	return nil
+}
+
+func (s *server) handlePutLease(c echo.Context) error {
+	host := c.Param("host")
+
+	if _, ok := s.mappingRepo.Get(c.Request().Context(), host); !ok {
+		err := errors.Errorf("%s is not found", host)
+		s.err(c, http.StatusNotFound, err)
+		return errors.WithStack(err)
+	}
+
+	err := s.mappingRepo.RenewLease(c.Request().Context(), host)
+	if err != nil {
+		s.err(c, http.StatusUnprocessableEntity, err)
+		return errors.WithStack(err)
+	}
+
+	c.NoContent(http.StatusNoContent)
+
+	return nil
+}
+
+func (s *server) handlePutStatus(c echo.Context) error {
+	var req struct {
+		Status domain.MappingStatus `json:"status"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		s.err(c, http.StatusBadRequest, err)
+		return errors.WithStack(err)
+	}
+
+	host := c.Param("host")
+
+	if _, ok := s.mappingRepo.Get(c.Request().Context(), host); !ok {
+		err := errors.Errorf("%s is not found", host)
+		s.err(c, http.StatusNotFound, err)
+		return errors.WithStack(err)
+	}
+
+	err := s.mappingRepo.UpdateStatus(c.Request().Context(), host, req.Status)
+	if err != nil {
+		s.err(c, http.StatusUnprocessableEntity, err)
+		return errors.WithStack(err)
+	}
+
+	c.NoContent(http.StatusNoContent)
+
+	return nil
+}
+
+func (s *server) handlePutRoutes(c echo.Context) error {
+	var req struct {
+		Routes domain.Routes `json:"routes"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		s.err(c, http.StatusBadRequest, err)
+		return errors.WithStack(err)
+	}
+
+	host := c.Param("host")
+
+	if _, ok := s.mappingRepo.Get(c.Request().Context(), host); !ok {
+		err := errors.Errorf("%s is not found", host)
+		s.err(c, http.StatusNotFound, err)
+		return errors.WithStack(err)
+	}
+
+	err := s.mappingRepo.UpdateRoutes(c.Request().Context(), host, req.Routes)
+	if err != nil {
+		s.err(c, http.StatusUnprocessableEntity, err)
+		return errors.WithStack(err)
+	}
+
+	c.NoContent(http.StatusNoContent)
+
+	return nil
+}
+
+func (s *server) handleGetLookup(c echo.Context) error {
+	host := c.Param("host")
+
+	ip, ok := s.mappingRepo.LookupIP(c.Request().Context(), host)
+	if !ok {
+		err := errors.Errorf("%s is not found", host)
+		s.err(c, http.StatusNotFound, err)
+		return errors.WithStack(err)
+	}
+
+	resp := struct {
+		IP   net.IP `json:"ip"`
+		IPv6 net.IP `json:"ipv6,omitempty"`
+	}{IP: ip}
+	resp.IPv6, _ = s.mappingRepo.LookupIPv6(c.Request().Context(), host)
+
+	c.JSON(http.StatusOK, resp)
+
+	return nil
+}
+
+func (s *server) handleGetReverse(c echo.Context) error {
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		err := errors.Errorf("%s is not a valid IP address", c.Param("ip"))
+		s.err(c, http.StatusBadRequest, err)
+		return errors.WithStack(err)
+	}
+
+	host, ok := s.mappingRepo.LookupHost(c.Request().Context(), ip)
+	if !ok {
+		err := errors.Errorf("%s is not found", ip)
+		s.err(c, http.StatusNotFound, err)
+		return errors.WithStack(err)
+	}
+
+	c.JSON(http.StatusOK, struct {
+		Host string `json:"host"`
+	}{Host: host})
+
+	return nil
+}
+
+func (s *server) handleGetMap(c echo.Context) error {
+	port, err := strconv.ParseUint(c.QueryParam("port"), 10, 16)
+	if err != nil {
+		s.err(c, http.StatusBadRequest, err)
+		return errors.WithStack(err)
+	}
+
+	addr := domain.Addr{Host: c.QueryParam("host"), Port: domain.Port(port)}
+
+	resp, err := s.mappingRepo.MapAddr(c.Request().Context(), addr)
+	if err != nil {
+		s.err(c, http.StatusNotFound, err)
+		return errors.WithStack(err)
+	}
+
+	c.JSON(http.StatusOK, resp)
+
+	return nil
+}
+
+func (s *server) handleGetStatus(c echo.Context) error {
+	resp := struct {
+		Healthy    bool             `json:"healthy"`
+		Components []*domain.Status `json:"components"`
+	}{Healthy: true}
+	var err error
+
+	resp.Components, err = s.statusRepo.List(c.Request().Context())
+	if err != nil {
+		s.err(c, http.StatusInternalServerError, err)
+		return errors.WithStack(err)
+	}
+	for _, st := range resp.Components {
+		resp.Healthy = resp.Healthy && st.Healthy
+	}
+
+	c.JSON(http.StatusOK, resp)
+
+	return nil
+}
+
+func (s *server) handleGetCACertificate(c echo.Context) error {
+	data, err := s.certRepo.GetCACertificate(c.Request().Context())
+	if err != nil {
+		s.err(c, http.StatusInternalServerError, err)
+		return errors.WithStack(err)
+	}
+
+	c.Blob(http.StatusOK, "application/x-pem-file", data)
+
+	return nil
+}
+
 func (s *server) handleDeleteMappings(c echo.Context) error {
 	err := s.mappingRepo.DeleteByHost(c.Request().Context(), c.Param("host"))
 	if err != nil {
@@ -140,3 +437,14 @@ func (s *server) handleDeleteMappings(c echo.Context) error {
 	c.NoContent(http.StatusNoContent)
 	return nil
 }
+
+func (s *server) handleDeleteReplica(c echo.Context) error {
+	err := s.mappingRepo.DeleteReplica(c.Request().Context(), c.Param("host"), c.Param("id"))
+	if err != nil {
+		s.err(c, http.StatusInternalServerError, err)
+		return errors.WithStack(err)
+	}
+
+	c.NoContent(http.StatusNoContent)
+	return nil
+}
@@ -4,10 +4,34 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 	"github.com/spf13/viper"
+
+	"github.com/srvc/ery/pkg/domain"
 )
 
 type Config struct {
-	Hostname string `toml:"hostname"`
+	Hostname string        `toml:"hostname"`
+	Aliases  []string      `toml:"aliases,omitempty"`
+	Routes   []RouteConfig `toml:"routes,omitempty"`
+}
+
+// RouteConfig forwards requests having the path prefix to the target, such as "api.myapp.ery:8080".
+type RouteConfig struct {
+	Path        string `toml:"path"`
+	Target      string `toml:"target"`
+	StripPrefix bool   `toml:"strip_prefix,omitempty" mapstructure:"strip_prefix"`
+}
+
+// routes returns routes in order of the configuration.
+func (c *Config) routes() (domain.Routes, error) {
+	var routes domain.Routes
+	for _, rc := range c.Routes {
+		r, err := domain.NewRoute(rc.Path, rc.Target, rc.StripPrefix)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
 }
 
 func loadConfig(fs afero.Fs, wd string, filename string) (cfg *Config, err error) {
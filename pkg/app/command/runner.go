@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
@@ -13,6 +15,14 @@ import (
 	"go.uber.org/zap"
 )
 
+var (
+	// leaseTTL is a lifetime of mappings created by runners.
+	// Mappings are removed by the daemon if the runner is killed without cleanup.
+	leaseTTL = 30 * time.Second
+	// leaseRenewInterval should be short enough to tolerate a few failures of renewals.
+	leaseRenewInterval = leaseTTL / 3
+)
+
 type Runner interface {
 	Run(ctx context.Context, name string, args []string) error
 }
@@ -59,6 +69,10 @@ func (r *runnerImpl) Run(ctx context.Context, name string, args []string) error
 
 	defer r.cleanup(context.TODO())
 
+	renewCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go r.renewLease(renewCtx)
+
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Stdin = r.inR
 	cmd.Stdout = r.outW
@@ -82,17 +96,73 @@ func (r *runnerImpl) setup(ctx context.Context) error {
 		return errors.WithStack(err)
 	}
 
+	r.port, err = r.register(ctx, 0)
+	return errors.WithStack(err)
+}
+
+// register creates a mapping for the command and its aliases, and returns the port that the command should listen on.
+// A free port is allocated if port is 0.
+func (r *runnerImpl) register(ctx context.Context, port domain.Port) (domain.Port, error) {
+	routes, err := r.cfg.routes()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
 	addr := domain.Addr{
 		Host: r.cfg.Hostname,
 		Port: r.defaultPort, // TODO: should be configurable
 	}
-	rAddr, err := r.mappingRepo.Create(ctx, addr, 0)
+	rAddr, err := r.mappingRepo.Create(ctx, addr, port,
+		domain.WithMeta(domain.MetaWorkingDir, r.workingDir),
+		domain.WithMeta(domain.MetaPID, strconv.Itoa(os.Getpid())),
+		domain.WithLease(leaseTTL),
+		domain.WithRoutes(routes),
+	)
 	if err != nil {
-		return errors.WithStack(err)
+		return 0, errors.WithStack(err)
+	}
+
+	for _, alias := range r.cfg.Aliases {
+		err = r.mappingRepo.AddAlias(ctx, r.cfg.Hostname, alias)
+		if err != nil {
+			r.log.Warn("failed to add an alias", zap.String("host", r.cfg.Hostname), zap.String("alias", alias), zap.Error(err))
+		}
+	}
+
+	return rAddr.Port, nil
+}
+
+func (r *runnerImpl) renewLease(ctx context.Context) {
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.renew(ctx)
+		}
 	}
-	r.port = rAddr.Port
+}
 
-	return nil
+// renew renews the lease of the mapping.
+// The mapping is created again with the same port if it has gone, e.g. it has expired while the daemon was unreachable or the daemon has restarted.
+func (r *runnerImpl) renew(ctx context.Context) {
+	err := r.mappingRepo.RenewLease(ctx, r.cfg.Hostname)
+	if err == nil {
+		return
+	}
+	if _, ok := r.mappingRepo.Get(ctx, r.cfg.Hostname); ok {
+		r.log.Warn("failed to renew a lease", zap.String("host", r.cfg.Hostname), zap.Error(err))
+		return
+	}
+
+	r.log.Info("mapping is not found, create it again", zap.String("host", r.cfg.Hostname))
+	_, err = r.register(ctx, r.port)
+	if err != nil {
+		r.log.Warn("failed to create a mapping again", zap.String("host", r.cfg.Hostname), zap.Error(err))
+	}
 }
 
 func (r *runnerImpl) cleanup(ctx context.Context) (err error) {
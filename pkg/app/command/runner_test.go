@@ -0,0 +1,51 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/srvc/ery/pkg/data/local"
+)
+
+func TestRunner_renew(t *testing.T) {
+	ctx := context.Background()
+
+	mappingRepo := local.NewMappingRepository(nil, false)
+	r := &runnerImpl{
+		mappingRepo: mappingRepo,
+		defaultPort: 80,
+		workingDir:  "/app",
+		log:         zap.NewNop(),
+		cfg:         &Config{Hostname: "myapp.ery", Aliases: []string{"www.myapp.ery"}},
+	}
+
+	var err error
+	r.port, err = r.register(ctx, 0)
+	if err != nil {
+		t.Fatalf("register returned an error: %v", err)
+	}
+
+	// e.g. the daemon has restarted without the mapping
+	err = mappingRepo.DeleteByHost(ctx, "myapp.ery")
+	if err != nil {
+		t.Fatalf("DeleteByHost returned an error: %v", err)
+	}
+
+	r.renew(ctx)
+
+	m, ok := mappingRepo.Get(ctx, "myapp.ery")
+	if !ok {
+		t.Fatal("mapping should be created again")
+	}
+	if got, want := m.PortMap[80], r.port; got != want {
+		t.Errorf("port 80 is mapped to %d, want %d that the command listens on", got, want)
+	}
+	if m.Lease == nil {
+		t.Error("mapping should have a lease")
+	}
+	if _, ok := mappingRepo.Get(ctx, "www.myapp.ery"); !ok {
+		t.Error("alias should be added again")
+	}
+}
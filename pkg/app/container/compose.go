@@ -0,0 +1,36 @@
+package container
+
+import (
+	"strings"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+// Labels attached by Docker Compose.
+const (
+	labelComposeProject         = "com.docker.compose.project"
+	labelComposeService         = "com.docker.compose.service"
+	labelComposeContainerNumber = "com.docker.compose.container-number"
+)
+
+// composeHostnames returns hostnames for a container managed by Docker Compose.
+// shared is "<service>.<project>.<tld>", requests for it are balanced across replicas of the service.
+// owned is "<number>.<service>.<project>.<tld>" pointing the container itself.
+func composeHostnames(c domain.Container, tld string) (shared, owned string, ok bool) {
+	project, service := c.Labels[labelComposeProject], c.Labels[labelComposeService]
+	if project == "" || service == "" {
+		return "", "", false
+	}
+
+	shared = strings.Join([]string{hostnameLabel(service), hostnameLabel(project), tld}, ".")
+	if n := c.Labels[labelComposeContainerNumber]; n != "" {
+		owned = hostnameLabel(n) + "." + shared
+	}
+
+	return shared, owned, true
+}
+
+// hostnameLabel converts the name into a DNS label, e.g. "My_App" -> "my-app".
+func hostnameLabel(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "-", -1))
+}
@@ -0,0 +1,204 @@
+package container
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+// Keys of container labels, they are prefixed with the package name (e.g. "tools.srvc.ery.hostname").
+const (
+	labelEnable      = "enable"       // "false" opts the container out
+	labelHostname    = "hostname"     // a comma separated list of hostnames
+	labelPort        = "port"         // "port.<virtual port>=<container port>"
+	labelIgnorePorts = "ignore_ports" // a comma separated list of container ports not to be mapped
+	labelWaitHealthy = "wait_healthy" // "true" exposes the container after its health check passes
+	labelProtocol    = "protocol"     // "protocol.<virtual port>=tcp"
+	labelRoute       = "route"        // "route.<index>.path", "route.<index>.target" and "route.<index>.strip_prefix"
+)
+
+// containerLabels is a set of ery's labels attached to a container.
+type containerLabels struct {
+	enabled      bool
+	hostnames    []string
+	ports        map[domain.Port][]domain.Port // container port -> virtual ports
+	ignoredPorts map[domain.Port]struct{}
+	waitHealthy  *bool                           // nil if not specified
+	protocols    map[domain.Port]domain.Protocol // virtual port -> protocol
+	routes       domain.Routes                   // in order of indices
+}
+
+// routeLabels is a set of labels configuring a route.
+type routeLabels struct {
+	path, target string
+	stripPrefix  bool
+}
+
+// parseLabels reads labels having the prefix. Invalid labels are ignored and returned as errors.
+func parseLabels(prefix string, labels map[string]string) (*containerLabels, []error) {
+	l := &containerLabels{
+		enabled:      true,
+		ports:        map[domain.Port][]domain.Port{},
+		ignoredPorts: map[domain.Port]struct{}{},
+		protocols:    map[domain.Port]domain.Protocol{},
+	}
+	var errs []error
+	indexedHostnames := map[int][]string{}
+	indexedRoutes := map[int]*routeLabels{}
+
+	for k, v := range labels {
+		if !strings.HasPrefix(k, prefix+".") {
+			continue
+		}
+		key := strings.TrimPrefix(k, prefix+".")
+
+		switch {
+		case key == labelEnable:
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, errors.Errorf("%s should be a boolean: %q", k, v))
+				continue
+			}
+			l.enabled = enabled
+		case key == labelHostname:
+			indexedHostnames[-1] = splitList(v)
+		case strings.HasPrefix(key, labelHostname+"."):
+			i, err := strconv.Atoi(strings.TrimPrefix(key, labelHostname+"."))
+			if err != nil || i < 0 {
+				errs = append(errs, errors.Errorf("%s should be suffixed with an index", k))
+				continue
+			}
+			indexedHostnames[i] = splitList(v)
+		case strings.HasPrefix(key, labelPort+"."):
+			vport, err := domain.PortFromString(strings.TrimPrefix(key, labelPort+"."))
+			if err != nil {
+				errs = append(errs, errors.Errorf("%s should be suffixed with a port number", k))
+				continue
+			}
+			cport, err := domain.PortFromString(v)
+			if err != nil {
+				errs = append(errs, errors.Errorf("%s should be a port number: %q", k, v))
+				continue
+			}
+			l.ports[cport] = append(l.ports[cport], vport)
+		case key == labelIgnorePorts:
+			for _, s := range splitList(v) {
+				port, err := domain.PortFromString(s)
+				if err != nil {
+					errs = append(errs, errors.Errorf("%s should be a list of port numbers: %q", k, v))
+					continue
+				}
+				l.ignoredPorts[port] = struct{}{}
+			}
+		case strings.HasPrefix(key, labelProtocol+"."):
+			vport, err := domain.PortFromString(strings.TrimPrefix(key, labelProtocol+"."))
+			if err != nil {
+				errs = append(errs, errors.Errorf("%s should be suffixed with a port number", k))
+				continue
+			}
+			protocol, err := domain.ProtocolFromString(v)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "%s is invalid", k))
+				continue
+			}
+			l.protocols[vport] = protocol
+		case strings.HasPrefix(key, labelRoute+"."):
+			parts := strings.SplitN(strings.TrimPrefix(key, labelRoute+"."), ".", 2)
+			i, err := strconv.Atoi(parts[0])
+			if err != nil || i < 0 || len(parts) != 2 {
+				errs = append(errs, errors.Errorf("%s should be suffixed with an index and a field", k))
+				continue
+			}
+			r, ok := indexedRoutes[i]
+			if !ok {
+				r = new(routeLabels)
+				indexedRoutes[i] = r
+			}
+			switch parts[1] {
+			case "path":
+				r.path = v
+			case "target":
+				r.target = v
+			case "strip_prefix":
+				r.stripPrefix, err = strconv.ParseBool(v)
+				if err != nil {
+					errs = append(errs, errors.Errorf("%s should be a boolean: %q", k, v))
+				}
+			default:
+				errs = append(errs, errors.Errorf("%s is an unknown label", k))
+			}
+		case key == labelWaitHealthy:
+			wait, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, errors.Errorf("%s should be a boolean: %q", k, v))
+				continue
+			}
+			l.waitHealthy = &wait
+		default:
+			errs = append(errs, errors.Errorf("%s is an unknown label", k))
+		}
+	}
+
+	indices := make([]int, 0, len(indexedHostnames))
+	for i := range indexedHostnames {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		l.hostnames = append(l.hostnames, indexedHostnames[i]...)
+	}
+	indices = indices[:0]
+	for i := range indexedRoutes {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		r := indexedRoutes[i]
+		route, err := domain.NewRoute(r.path, r.target, r.stripPrefix)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "%s.%s.%d is invalid", prefix, labelRoute, i))
+			continue
+		}
+		l.routes = append(l.routes, route)
+	}
+	for _, vports := range l.ports {
+		sort.Slice(vports, func(i, j int) bool { return vports[i] < vports[j] })
+	}
+
+	return l, errs
+}
+
+// virtualPorts returns ports of virtual hosts for the container port.
+// Ports without explicit mappings are mapped to the same number.
+func (l *containerLabels) virtualPorts(cport domain.Port) []domain.Port {
+	if _, ok := l.ignoredPorts[cport]; ok {
+		return nil
+	}
+	if vports, ok := l.ports[cport]; ok {
+		return vports
+	}
+	return []domain.Port{cport}
+}
+
+// waitsHealthy returns true if the container should be exposed after its health check passes.
+// The label takes precedence over the default.
+func (l *containerLabels) waitsHealthy(defaultValue bool) bool {
+	if l.waitHealthy != nil {
+		return *l.waitHealthy
+	}
+	return defaultValue
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
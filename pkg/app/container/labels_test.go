@@ -0,0 +1,161 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+func TestParseLabels(t *testing.T) {
+	prefix := "tools.srvc.ery"
+	yes, no := true, false
+
+	route := func(path, target string, strip bool) domain.Route {
+		r, err := domain.NewRoute(path, target, strip)
+		if err != nil {
+			t.Fatalf("NewRoute returned an error: %v", err)
+		}
+		return r
+	}
+
+	cases := []struct {
+		test   string
+		labels map[string]string
+		want   containerLabels
+		errs   int
+	}{
+		{
+			test:   "no labels",
+			labels: map[string]string{"com.docker.compose.service": "web"},
+			want:   containerLabels{enabled: true},
+		},
+		{
+			test:   "disabled",
+			labels: map[string]string{prefix + ".enable": "false"},
+			want:   containerLabels{enabled: false},
+		},
+		{
+			test: "hostnames",
+			labels: map[string]string{
+				prefix + ".hostname.1": "admin.myapp.ery",
+				prefix + ".hostname":   "myapp.ery, www.myapp.ery,",
+				prefix + ".hostname.0": "api.myapp.ery",
+			},
+			want: containerLabels{enabled: true, hostnames: []string{"myapp.ery", "www.myapp.ery", "api.myapp.ery", "admin.myapp.ery"}},
+		},
+		{
+			test: "ports",
+			labels: map[string]string{
+				prefix + ".port.80":      "3000",
+				prefix + ".port.8080":    "3000",
+				prefix + ".port.443":     "3443",
+				prefix + ".ignore_ports": "9229, 9230",
+			},
+			want: containerLabels{
+				enabled:      true,
+				ports:        map[domain.Port][]domain.Port{3000: {80, 8080}, 3443: {443}},
+				ignoredPorts: map[domain.Port]struct{}{9229: {}, 9230: {}},
+			},
+		},
+		{
+			test: "protocols and health checks",
+			labels: map[string]string{
+				prefix + ".protocol.5432": "tcp",
+				prefix + ".protocol.53":   "udp",
+				prefix + ".wait_healthy":  "true",
+			},
+			want: containerLabels{
+				enabled:     true,
+				waitHealthy: &yes,
+				protocols:   map[domain.Port]domain.Protocol{5432: domain.ProtocolTCP, 53: domain.ProtocolUDP},
+			},
+		},
+		{
+			test: "routes",
+			labels: map[string]string{
+				prefix + ".route.1.path":         "/",
+				prefix + ".route.1.target":       ":3000",
+				prefix + ".route.0.path":         "/api",
+				prefix + ".route.0.target":       "api.myapp.ery",
+				prefix + ".route.0.strip_prefix": "true",
+			},
+			want: containerLabels{
+				enabled: true,
+				routes:  domain.Routes{route("/api", "api.myapp.ery", true), route("/", ":3000", false)},
+			},
+		},
+		{
+			test: "invalid labels",
+			labels: map[string]string{
+				prefix + ".enable":         "maybe",
+				prefix + ".hostname.x":     "myapp.ery",
+				prefix + ".port.http":      "3000",
+				prefix + ".port.80":        "web",
+				prefix + ".ignore_ports":   "9229,debug",
+				prefix + ".protocol.80":    "ftp",
+				prefix + ".route.0.path":   "api",
+				prefix + ".route.0.target": "api.myapp.ery",
+				prefix + ".route.1.host":   "api.myapp.ery",
+				prefix + ".wait_healthy":   "no",
+				prefix + ".hostnames":      "myapp.ery",
+			},
+			want: containerLabels{
+				enabled:      true,
+				ignoredPorts: map[domain.Port]struct{}{9229: {}},
+			},
+			errs: 11,
+		},
+		{
+			test:   "wait_healthy false",
+			labels: map[string]string{prefix + ".wait_healthy": "false"},
+			want:   containerLabels{enabled: true, waitHealthy: &no},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.test, func(t *testing.T) {
+			got, errs := parseLabels(prefix, c.labels)
+			if len(errs) != c.errs {
+				t.Errorf("parseLabels returned %d errors, want %d: %v", len(errs), c.errs, errs)
+			}
+
+			want := c.want
+			if want.ports == nil {
+				want.ports = map[domain.Port][]domain.Port{}
+			}
+			if want.ignoredPorts == nil {
+				want.ignoredPorts = map[domain.Port]struct{}{}
+			}
+			if want.protocols == nil {
+				want.protocols = map[domain.Port]domain.Protocol{}
+			}
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("parseLabels returned %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestContainerLabels_virtualPorts(t *testing.T) {
+	l, _ := parseLabels("tools.srvc.ery", map[string]string{
+		"tools.srvc.ery.port.80":      "3000",
+		"tools.srvc.ery.port.8080":    "3000",
+		"tools.srvc.ery.ignore_ports": "9229",
+	})
+
+	cases := []struct {
+		cport domain.Port
+		want  []domain.Port
+	}{
+		{cport: 3000, want: []domain.Port{80, 8080}},
+		{cport: 5432, want: []domain.Port{5432}},
+		{cport: 9229},
+	}
+
+	for _, c := range cases {
+		if got := l.virtualPorts(c.cport); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("virtualPorts(%d) returned %v, want %v", c.cport, got, c.want)
+		}
+	}
+}
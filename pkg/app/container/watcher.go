@@ -2,6 +2,7 @@ package container
 
 import (
 	"context"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -17,38 +18,70 @@ type Watcher interface {
 	ListenEvents(context.Context) error
 }
 
+// Config is a configuration object concerning in the container watcher.
+type Config struct {
+	TLD string
+	// LabelPrefix is a prefix of container labels that configure how containers are mapped.
+	LabelPrefix string
+	// RouteByIP makes mappings point container IPs and exposed ports instead of published ports.
+	RouteByIP bool
+	// WaitHealthy makes containers having health checks exposed after they become healthy.
+	// It can be overridden with a label for each container.
+	WaitHealthy bool
+}
+
 // NewWatcher creates a new Watcher instance concerned to containers.
 func NewWatcher(
 	mappingRepo domain.MappingRepository,
 	containerRepos []domain.ContainerRepository,
-	tld, labelHostname string,
+	cfg *Config,
 ) Watcher {
 	return &watcherImpl{
+		Config:         cfg,
 		mappingRepo:    mappingRepo,
 		containerRepos: containerRepos,
-		tld:            tld,
-		labelHostname:  labelHostname,
 		hostsByCID:     new(sync.Map),
+		listed:         map[domain.ContainerRepository]struct{}{},
 		log:            zap.L().Named("watcher"),
 	}
 }
 
 type watcherImpl struct {
+	*Config
 	hostsByCID     *sync.Map
 	mappingRepo    domain.MappingRepository
 	containerRepos []domain.ContainerRepository
-	tld            string
-	labelHostname  string
 	log            *zap.Logger
+
+	// listed is a set of repositories that have been listed on reconciling.
+	// It is accessed only before processing events or by the processor.
+	listed map[domain.ContainerRepository]struct{}
+}
+
+// containerEvent is an event of the repository.
+type containerEvent struct {
+	domain.ContainerEvent
+	repo       domain.ContainerRepository
+	containers []domain.Container // running containers listed on resyncing
 }
 
 func (w *watcherImpl) ListenEvents(pctx context.Context) error {
-	evCh := make(chan domain.ContainerEvent)
+	evCh := make(chan containerEvent)
 	defer close(evCh)
 
 	eg, ctx := errgroup.WithContext(pctx)
 	w.log.Debug("start watching container events")
 
+	for _, repo := range w.containerRepos {
+		containers, err := repo.List(ctx)
+		if err != nil {
+			// mappings are reconciled on resyncing after it is reconnected
+			w.log.Warn("failed to list containers, skip reconciling their mappings", zap.Error(err))
+			continue
+		}
+		w.reconcile(ctx, repo, containers)
+	}
+
 	// collectors
 	for _, containerRepo := range w.containerRepos {
 		repo := containerRepo
@@ -57,7 +90,17 @@ func (w *watcherImpl) ListenEvents(pctx context.Context) error {
 			for {
 				select {
 				case ev := <-origEvCh:
-					evCh <- ev
+					cev := containerEvent{ContainerEvent: ev, repo: repo}
+					if ev.Type == domain.ContainerEventResync {
+						// list containers here not to block processing events of other repositories
+						containers, err := repo.List(ctx)
+						if err != nil {
+							w.log.Warn("failed to list containers, skip reconciling their mappings", zap.Error(err))
+							continue
+						}
+						cev.containers = containers
+					}
+					evCh <- cev
 				case err := <-origErrCh:
 					return errors.WithStack(err)
 				case <-ctx.Done():
@@ -80,6 +123,12 @@ func (w *watcherImpl) ListenEvents(pctx context.Context) error {
 					w.handleCreated(ctx, ev.Container)
 				case domain.ContainerEventDestroyed:
 					w.handleDestroyed(ctx, ev.Container)
+				case domain.ContainerEventHealthChanged:
+					w.handleHealthChanged(ctx, ev.Container)
+				case domain.ContainerEventUpdated:
+					w.handleUpdated(ctx, ev.Container)
+				case domain.ContainerEventResync:
+					w.reconcile(ctx, ev.repo, ev.containers)
 				}
 			case <-ctx.Done():
 				w.log.Debug("stop processing container events", zap.Error(ctx.Err()))
@@ -91,43 +140,396 @@ func (w *watcherImpl) ListenEvents(pctx context.Context) error {
 	return errors.WithStack(eg.Wait())
 }
 
+// registration contains hosts registered for a container.
+type registration struct {
+	hosts       []string // owned by the container
+	sharedHosts []string // shared with replicas of the same service
+	starting    bool     // waiting for the health check, replicas are not registered yet
+
+	targetHost  string
+	targetPorts map[domain.Port][]domain.Port
+
+	// restoredTargets are target addresses of mappings taken over by reconcile.
+	// It is nil unless the registration is taken over, since container ports of restored mappings are unknown.
+	restoredTargets map[domain.Addr]struct{}
+}
+
+// targetAddrs returns addresses that mappings of the registration are proxied to.
+func (r *registration) targetAddrs(labels *containerLabels) map[domain.Addr]struct{} {
+	addrs := map[domain.Addr]struct{}{}
+	for cport, ports := range r.targetPorts {
+		if len(labels.virtualPorts(cport)) == 0 {
+			continue
+		}
+		for _, p := range ports {
+			addrs[domain.Addr{Host: r.targetHost, Port: p}] = struct{}{}
+		}
+	}
+	return addrs
+}
+
+// reconcile takes over mappings owned by containers running in the repository (e.g. restored ones), and deletes mappings owned by stopped containers.
+// Mappings are deleted only after every repository has been listed, since their owners may be running in repositories unreachable so far.
+// Containers registered on events are skipped, they are reconciled with the events.
+func (w *watcherImpl) reconcile(ctx context.Context, repo domain.ContainerRepository, containers []domain.Container) {
+	w.listed[repo] = struct{}{}
+
+	running := map[string]struct{}{}
+	for _, c := range containers {
+		if _, ok := w.hostsByCID.Load(c.ID); !ok {
+			running[c.ID] = struct{}{}
+		}
+	}
+	deletable := len(w.listed) == len(w.containerRepos)
+
+	// stopped returns true if the container is neither running nor registered in any repository.
+	stopped := func(id string) bool {
+		if !deletable {
+			return false
+		}
+		_, registered := w.hostsByCID.Load(id)
+		return !registered
+	}
+
+	mappings, err := w.mappingRepo.List(ctx)
+	if err != nil {
+		w.log.Warn("failed to list mappings, skip reconciling mappings", zap.Error(err))
+		return
+	}
+
+	for _, m := range mappings {
+		for _, id := range replicaIDs(m) {
+			if _, ok := running[id]; ok {
+				reg := w.registration(id)
+				reg.sharedHosts = append(reg.sharedHosts, m.VirtualHost)
+				for _, replicas := range m.Replicas {
+					for _, rep := range replicas {
+						if rep.ID == id {
+							reg.restoredTargets[domain.Addr{Host: rep.Host, Port: rep.Port}] = struct{}{}
+						}
+					}
+				}
+				continue
+			}
+			if !stopped(id) {
+				continue
+			}
+			w.log.Info("delete a replica of a stopped container", zap.String("host", m.VirtualHost), zap.String("container_id", id))
+			err = w.mappingRepo.DeleteReplica(ctx, m.VirtualHost, id)
+			if err != nil {
+				w.log.Warn("failed to delete a replica", zap.Error(err), zap.String("host", m.VirtualHost), zap.String("container_id", id))
+			}
+		}
+
+		cid, ok := m.Meta[domain.MetaContainerID]
+		if !ok {
+			continue
+		}
+		if _, ok := running[cid]; ok {
+			reg := w.registration(cid)
+			reg.hosts = append(reg.hosts, m.VirtualHost)
+			for port := range m.PortMap {
+				reg.restoredTargets[m.Map(port)] = struct{}{}
+			}
+			continue
+		}
+		if !stopped(cid) {
+			continue
+		}
+		w.log.Info("delete a mapping owned by a stopped container", zap.String("host", m.VirtualHost), zap.String("container_id", cid))
+		err = w.mappingRepo.DeleteByHost(ctx, m.VirtualHost)
+		if err != nil {
+			w.log.Warn("failed to delete a mapping", zap.Error(err), zap.String("host", m.VirtualHost), zap.String("container_id", cid))
+		}
+	}
+}
+
+// registration returns a registration taken over by reconcile, it is created if not exists.
+func (w *watcherImpl) registration(cid string) *registration {
+	v, _ := w.hostsByCID.LoadOrStore(cid, &registration{restoredTargets: map[domain.Addr]struct{}{}})
+	return v.(*registration)
+}
+
 func (w *watcherImpl) handleCreated(ctx context.Context, c domain.Container) {
-	hostnames := []string{}
+	// containers may be notified twice by the initial listing and the event stream
+	if v, ok := w.hostsByCID.Load(c.ID); ok {
+		if reg, ok := v.(*registration); ok && reg.restoredTargets != nil {
+			// the container may have been restarted with other ports while ery was stopped
+			w.handleUpdated(ctx, c)
+			return
+		}
+		w.log.Debug("container has already been registered", zap.String("container_id", c.ID))
+		return
+	}
+
+	labels, errs := parseLabels(w.LabelPrefix, c.Labels)
+	for _, err := range errs {
+		w.log.Warn("ignore an invalid label", zap.Error(err), zap.String("container_id", c.ID))
+	}
+	if !labels.enabled {
+		w.log.Debug("container is disabled with the label", zap.String("container_id", c.ID))
+		return
+	}
+	if c.Paused {
+		w.log.Debug("container is paused", zap.String("container_id", c.ID))
+		return
+	}
+	wait := labels.waitsHealthy(w.WaitHealthy)
+	if wait && c.Health == domain.ContainerHealthUnhealthy {
+		w.log.Info("container is unhealthy, wait until it becomes healthy", zap.String("container_id", c.ID))
+		return
+	}
+
+	reg := w.newRegistration(c, labels)
+	reg.starting = wait && c.Health == domain.ContainerHealthStarting
+	for cport := range labels.ports {
+		if _, ok := reg.targetPorts[cport]; !ok {
+			w.log.Warn("port in the label is not available", zap.Any("port", cport), zap.String("container_id", c.ID))
+		}
+	}
+	w.hostsByCID.Store(c.ID, reg)
+
+	if reg.starting {
+		// proxies answer "service starting" for owned hosts, and shared hosts are balanced only across healthy replicas
+		w.log.Info("container is starting, wait until it becomes healthy", zap.String("container_id", c.ID))
+		w.createMappings(ctx, c, labels, reg.hosts, nil, domain.WithStatus(domain.MappingStatusStarting))
+		return
+	}
+	w.createMappings(ctx, c, labels, reg.hosts, reg.sharedHosts)
+}
+
+// newRegistration returns hosts that should be registered for the container.
+// Containers managed by Docker Compose are registered with compose hostnames in addition to "<name>.<network>.<platform>.<tld>".
+func (w *watcherImpl) newRegistration(c domain.Container, labels *containerLabels) *registration {
+	reg := new(registration)
+	reg.targetHost, reg.targetPorts = w.targets(c)
 	for _, n := range c.Networks {
-		hostname := strings.Join([]string{c.Name, n.Name, c.Platform.String(), w.tld}, ".")
-		hostnames = append(hostnames, hostname)
+		hostname := strings.Join([]string{c.Name, n.Name, c.Platform.String(), w.TLD}, ".")
+		reg.hosts = append(reg.hosts, hostname)
+	}
+	if shared, owned, ok := composeHostnames(c, w.TLD); ok {
+		reg.sharedHosts = append(reg.sharedHosts, shared)
+		if owned != "" {
+			reg.hosts = append(reg.hosts, owned)
+		}
+	}
+	reg.hosts = append(reg.hosts, labels.hostnames...)
+	return reg
+}
+
+// handleUpdated reconciles mappings of the container with its current meta data.
+// Mappings of hosts that are no longer derived are deleted, and ones of new hosts are created.
+// All mappings are recreated if their targets have changed.
+// Paused containers are not exposed until they are unpaused.
+func (w *watcherImpl) handleUpdated(ctx context.Context, c domain.Container) {
+	v, registered := w.hostsByCID.Load(c.ID)
+	if !registered {
+		w.handleCreated(ctx, c)
+		return
+	}
+	reg, ok := v.(*registration)
+	if !ok {
+		return
+	}
+
+	// errors have been reported on creating
+	labels, _ := parseLabels(w.LabelPrefix, c.Labels)
+	if c.Paused || !labels.enabled {
+		w.log.Info("container is paused, delete its mappings", zap.String("container_id", c.ID))
+		w.handleDestroyed(ctx, c)
+		return
+	}
+
+	next := w.newRegistration(c, labels)
+	next.starting = reg.starting
+	changed := next.targetHost != reg.targetHost || !reflect.DeepEqual(next.targetPorts, reg.targetPorts)
+	if reg.restoredTargets != nil {
+		changed = !reflect.DeepEqual(next.targetAddrs(labels), reg.restoredTargets)
+	}
+	if changed {
+		w.log.Info("targets of the container have changed, recreate its mappings", zap.String("container_id", c.ID))
+		w.handleDestroyed(ctx, c)
+		w.handleCreated(ctx, c)
+		return
+	}
+
+	for _, h := range subtractHosts(reg.hosts, next.hosts) {
+		err := w.mappingRepo.DeleteByHost(ctx, h)
+		if err != nil {
+			w.log.Warn("failed to delete a mapping", zap.Error(err), zap.String("host", h), zap.String("container_id", c.ID))
+		}
+	}
+	for _, h := range subtractHosts(reg.sharedHosts, next.sharedHosts) {
+		err := w.mappingRepo.DeleteReplica(ctx, h, c.ID)
+		if err != nil {
+			w.log.Warn("failed to delete a replica", zap.Error(err), zap.String("host", h), zap.String("container_id", c.ID))
+		}
+	}
+
+	w.hostsByCID.Store(c.ID, next)
+
+	hosts := subtractHosts(next.hosts, reg.hosts)
+	if next.starting {
+		w.createMappings(ctx, c, labels, hosts, nil, domain.WithStatus(domain.MappingStatusStarting))
+		return
 	}
-	if n, ok := c.Labels[w.labelHostname]; ok {
-		hostnames = append(hostnames, n)
+	w.createMappings(ctx, c, labels, hosts, subtractHosts(next.sharedHosts, reg.sharedHosts))
+}
+
+// handleHealthChanged exposes containers waiting for health checks when they become healthy,
+// and removes mappings of containers that have become unhealthy.
+func (w *watcherImpl) handleHealthChanged(ctx context.Context, c domain.Container) {
+	// errors have been reported on creating
+	labels, _ := parseLabels(w.LabelPrefix, c.Labels)
+	if !labels.enabled || !labels.waitsHealthy(w.WaitHealthy) {
+		return
 	}
 
-	w.hostsByCID.Store(c.ID, hostnames)
+	v, registered := w.hostsByCID.Load(c.ID)
 
-	for cport, hports := range c.PortBindings {
-		for _, hport := range hports {
-			for _, host := range hostnames {
-				lAddr := domain.Addr{Host: host, Port: cport}
-				rAddr, err := w.mappingRepo.Create(ctx, lAddr, hport)
-				if err == nil {
-					w.log.Info("created a new mapping", zap.Stringer("src_addr", &lAddr), zap.Stringer("dest_addr", &rAddr), zap.String("container_id", c.ID))
-				} else {
-					w.log.Warn("failed to create a new mapping", zap.Error(err), zap.Stringer("src_addr", &lAddr), zap.Any("dest_port", hport), zap.String("container_id", c.ID))
+	switch c.Health {
+	case domain.ContainerHealthHealthy:
+		if !registered {
+			w.handleCreated(ctx, c)
+			return
+		}
+		reg, ok := v.(*registration)
+		if !ok || !reg.starting {
+			return
+		}
+		reg.starting = false
+		w.log.Info("container has become healthy", zap.String("container_id", c.ID))
+		for _, h := range reg.hosts {
+			err := w.mappingRepo.UpdateStatus(ctx, h, domain.MappingStatusReady)
+			if err != nil {
+				w.log.Warn("failed to update a mapping status", zap.Error(err), zap.String("host", h), zap.String("container_id", c.ID))
+			}
+		}
+		w.createMappings(ctx, c, labels, nil, reg.sharedHosts)
+	case domain.ContainerHealthUnhealthy:
+		if registered {
+			w.log.Info("container has become unhealthy, delete its mappings", zap.String("container_id", c.ID))
+			w.handleDestroyed(ctx, c)
+		}
+	}
+}
+
+// createMappings creates mappings of the container for owned hosts and hosts shared with replicas.
+func (w *watcherImpl) createMappings(ctx context.Context, c domain.Container, labels *containerLabels, hosts, sharedHosts []string, opts ...domain.CreateOption) {
+	targetHost, targetPorts := w.targets(c)
+	if targetHost != "" {
+		opts = append(opts, domain.WithTargetHost(targetHost))
+	}
+	if len(labels.routes) > 0 {
+		opts = append(opts, domain.WithRoutes(labels.routes))
+	}
+
+	for cport, ports := range targetPorts {
+		for _, vport := range labels.virtualPorts(cport) {
+			protocol, ok := labels.protocols[vport]
+			if !ok {
+				protocol = c.Protocols[cport]
+			}
+			vopts := append(append([]domain.CreateOption{}, opts...), domain.WithProtocol(protocol))
+			for _, port := range ports {
+				for _, host := range hosts {
+					w.create(ctx, c, domain.Addr{Host: host, Port: vport}, port, append(vopts, domain.WithMeta(domain.MetaContainerID, c.ID))...)
+				}
+				for _, host := range sharedHosts {
+					w.create(ctx, c, domain.Addr{Host: host, Port: vport}, port, append(vopts, domain.WithReplica(c.ID))...)
 				}
 			}
 		}
 	}
 }
 
+// targets returns a host and ports for each container port that requests should be proxied to.
+// They are the container IP and exposed ports in RouteByIP mode, or the host of published ports otherwise.
+func (w *watcherImpl) targets(c domain.Container) (host string, ports map[domain.Port][]domain.Port) {
+	if c.HostIP != nil {
+		host = c.HostIP.String()
+	}
+	if !w.RouteByIP {
+		return host, c.PortBindings
+	}
+
+	ip, ok := c.IP()
+	if !ok {
+		w.log.Debug("container does not have IP addresses, use published ports", zap.String("container_id", c.ID))
+		return host, c.PortBindings
+	}
+
+	ports = map[domain.Port][]domain.Port{}
+	for _, p := range c.ExposedPorts {
+		ports[p] = []domain.Port{p}
+	}
+	for p := range c.PortBindings {
+		ports[p] = []domain.Port{p}
+	}
+
+	return ip.String(), ports
+}
+
+func (w *watcherImpl) create(ctx context.Context, c domain.Container, lAddr domain.Addr, hport domain.Port, opts ...domain.CreateOption) {
+	rAddr, err := w.mappingRepo.Create(ctx, lAddr, hport, opts...)
+	if err == nil {
+		w.log.Info("created a new mapping", zap.Stringer("src_addr", &lAddr), zap.Stringer("dest_addr", &rAddr), zap.String("container_id", c.ID))
+	} else {
+		w.log.Warn("failed to create a new mapping", zap.Error(err), zap.Stringer("src_addr", &lAddr), zap.Any("dest_port", hport), zap.String("container_id", c.ID))
+	}
+}
+
 func (w *watcherImpl) handleDestroyed(ctx context.Context, c domain.Container) {
-	if v, ok := w.hostsByCID.Load(c.ID); ok {
-		if hosts, ok := v.([]string); ok {
-			for _, h := range hosts {
-				err := w.mappingRepo.DeleteByHost(ctx, h)
-				if err != nil {
-					w.log.Warn("failed to delete a mapping", zap.Error(err), zap.String("host", h), zap.String("container_id", c.ID))
-				}
+	v, ok := w.hostsByCID.Load(c.ID)
+	if !ok {
+		return
+	}
+	defer w.hostsByCID.Delete(c.ID)
+
+	reg, ok := v.(*registration)
+	if !ok {
+		return
+	}
+	for _, h := range reg.hosts {
+		err := w.mappingRepo.DeleteByHost(ctx, h)
+		if err != nil {
+			w.log.Warn("failed to delete a mapping", zap.Error(err), zap.String("host", h), zap.String("container_id", c.ID))
+		}
+	}
+	for _, h := range reg.sharedHosts {
+		err := w.mappingRepo.DeleteReplica(ctx, h, c.ID)
+		if err != nil {
+			w.log.Warn("failed to delete a replica", zap.Error(err), zap.String("host", h), zap.String("container_id", c.ID))
+		}
+	}
+}
+
+// subtractHosts returns hosts in a that are not in b.
+func subtractHosts(a, b []string) []string {
+	excluded := make(map[string]struct{}, len(b))
+	for _, h := range b {
+		excluded[h] = struct{}{}
+	}
+	var out []string
+	for _, h := range a {
+		if _, ok := excluded[h]; !ok {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// replicaIDs returns unique IDs of replicas in the mapping.
+func replicaIDs(m *domain.Mapping) []string {
+	var ids []string
+	seen := map[string]struct{}{}
+	for _, replicas := range m.Replicas {
+		for _, rep := range replicas {
+			if _, ok := seen[rep.ID]; !ok {
+				seen[rep.ID] = struct{}{}
+				ids = append(ids, rep.ID)
 			}
 		}
-		w.hostsByCID.Delete(c.ID)
 	}
+	return ids
 }
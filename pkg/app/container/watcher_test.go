@@ -0,0 +1,164 @@
+package container
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/srvc/ery/pkg/data/local"
+	"github.com/srvc/ery/pkg/domain"
+)
+
+type fakeContainerRepository struct {
+	containers []domain.Container
+}
+
+func (r *fakeContainerRepository) List(context.Context) ([]domain.Container, error) {
+	return r.containers, nil
+}
+
+func (r *fakeContainerRepository) ListenEvent(context.Context) (<-chan domain.ContainerEvent, <-chan error) {
+	return make(chan domain.ContainerEvent), make(chan error)
+}
+
+func TestWatcher_handleCreated_Reconciled(t *testing.T) {
+	cases := []struct {
+		test     string
+		hostPort domain.Port // published port after ery has restarted
+	}{
+		{test: "container has not changed", hostPort: 32768},
+		{test: "container has been restarted with another port", hostPort: 32800},
+	}
+
+	for _, c := range cases {
+		t.Run(c.test, func(t *testing.T) {
+			ctx := context.Background()
+
+			// a mapping restored from the state file
+			mappingRepo := local.NewMappingRepository(nil, false)
+			_, err := mappingRepo.Create(ctx, domain.HTTPAddr("web.ery"), 32768, domain.WithMeta(domain.MetaContainerID, "abc"))
+			if err != nil {
+				t.Fatalf("Create returned an error: %v", err)
+			}
+
+			container := domain.Container{
+				ID:           "abc",
+				Name:         "web",
+				Labels:       map[string]string{"tools.srvc.ery.hostname": "web.ery"},
+				PortBindings: map[domain.Port][]domain.Port{80: {c.hostPort}},
+			}
+			containerRepo := &fakeContainerRepository{containers: []domain.Container{container}}
+			w := NewWatcher(mappingRepo, []domain.ContainerRepository{containerRepo}, &Config{TLD: "ery", LabelPrefix: "tools.srvc.ery"}).(*watcherImpl)
+
+			w.reconcile(ctx, containerRepo, containerRepo.containers)
+			w.handleCreated(ctx, container)
+
+			m, ok := mappingRepo.Get(ctx, "web.ery")
+			if !ok {
+				t.Fatal("mapping of web.ery should exist")
+			}
+			if got, want := m.PortMap[80], c.hostPort; got != want {
+				t.Errorf("port 80 is mapped to %d, want %d", got, want)
+			}
+			if got, want := m.Meta[domain.MetaContainerID], "abc"; got != want {
+				t.Errorf("mapping is owned by %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWatcher_reconcile(t *testing.T) {
+	ctx := context.Background()
+
+	// mappings restored from the state file, "abc" is running on docker and "def" is running on podman
+	mappingRepo := local.NewMappingRepository(nil, false)
+	for host, cid := range map[string]string{"web.ery": "abc", "db.ery": "def", "stopped.ery": "ghi"} {
+		_, err := mappingRepo.Create(ctx, domain.HTTPAddr(host), 32768, domain.WithMeta(domain.MetaContainerID, cid))
+		if err != nil {
+			t.Fatalf("Create returned an error: %v", err)
+		}
+	}
+
+	docker := &fakeContainerRepository{containers: []domain.Container{{ID: "abc", PortBindings: map[domain.Port][]domain.Port{80: {32768}}}}}
+	podman := &fakeContainerRepository{containers: []domain.Container{{ID: "def", PortBindings: map[domain.Port][]domain.Port{80: {32768}}}}}
+	w := NewWatcher(mappingRepo, []domain.ContainerRepository{docker, podman}, &Config{TLD: "ery", LabelPrefix: "tools.srvc.ery"}).(*watcherImpl)
+
+	exists := func(host string) bool {
+		_, ok := mappingRepo.Get(ctx, host)
+		return ok
+	}
+
+	// podman is unreachable, its mappings can not be distinguished from ones of stopped containers
+	w.reconcile(ctx, docker, docker.containers)
+
+	if _, ok := w.hostsByCID.Load("abc"); !ok {
+		t.Error("mapping of the running container should be taken over")
+	}
+	for _, host := range []string{"web.ery", "db.ery", "stopped.ery"} {
+		if !exists(host) {
+			t.Errorf("mapping of %s should be kept until every repository is listed", host)
+		}
+	}
+
+	// podman is reconnected
+	w.reconcile(ctx, podman, podman.containers)
+
+	if _, ok := w.hostsByCID.Load("def"); !ok {
+		t.Error("mapping of the container running on the reconnected repository should be taken over")
+	}
+	for _, host := range []string{"web.ery", "db.ery"} {
+		if !exists(host) {
+			t.Errorf("mapping of %s should be kept", host)
+		}
+	}
+	if exists("stopped.ery") {
+		t.Error("mapping of the stopped container should be deleted")
+	}
+}
+
+func TestWatcher_newRegistration(t *testing.T) {
+	cases := []struct {
+		test        string
+		labels      map[string]string
+		hosts       []string
+		sharedHosts []string
+	}{
+		{
+			test:  "container",
+			hosts: []string{"myproj_web_2.myproj_default.docker.ery"},
+		},
+		{
+			test: "compose container",
+			labels: map[string]string{
+				"com.docker.compose.project":          "myproj",
+				"com.docker.compose.service":          "web",
+				"com.docker.compose.container-number": "2",
+			},
+			hosts:       []string{"myproj_web_2.myproj_default.docker.ery", "2.web.myproj.ery"},
+			sharedHosts: []string{"web.myproj.ery"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.test, func(t *testing.T) {
+			w := NewWatcher(nil, nil, &Config{TLD: "ery", LabelPrefix: "tools.srvc.ery"}).(*watcherImpl)
+			container := domain.Container{
+				ID:       "abc",
+				Name:     "myproj_web_2",
+				Platform: domain.ContainerPlatformDocker,
+				Labels:   c.labels,
+				Networks: []domain.ContainerNetwork{{Name: "myproj_default"}},
+			}
+			labels, _ := parseLabels(w.LabelPrefix, container.Labels)
+
+			reg := w.newRegistration(container, labels)
+
+			if got, want := reg.hosts, c.hosts; !reflect.DeepEqual(got, want) {
+				t.Errorf("hosts are %v, want %v", got, want)
+			}
+			if got, want := reg.sharedHosts, c.sharedHosts; !reflect.DeepEqual(got, want) {
+				t.Errorf("shared hosts are %v, want %v", got, want)
+			}
+		})
+	}
+}
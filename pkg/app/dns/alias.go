@@ -0,0 +1,36 @@
+package dns
+
+import (
+	"context"
+	"strings"
+
+	godns "github.com/miekg/dns"
+)
+
+// aliasRecords returns a CNAME record pointing the canonical name for aliases of mappings,
+// followed by records of the canonical name.
+func (s *server) aliasRecords(q godns.Question) (rrs []godns.RR, exists bool) {
+	host := strings.TrimSuffix(q.Name, ".")
+
+	m, ok := s.mappingRepo.Get(context.TODO(), host)
+	if !ok || m.VirtualHost == host || strings.HasPrefix(m.VirtualHost, "*.") {
+		return
+	}
+
+	for _, alias := range m.Aliases {
+		if alias != host {
+			continue
+		}
+		exists = true
+
+		target := godns.Fqdn(m.VirtualHost)
+		rrs = append(rrs, &godns.CNAME{Hdr: s.header(q.Name, godns.TypeCNAME), Target: target})
+		if q.Qtype != godns.TypeCNAME {
+			got, _ := s.records(godns.Question{Name: target, Qtype: q.Qtype, Qclass: q.Qclass})
+			rrs = append(rrs, got...)
+		}
+		return
+	}
+
+	return
+}
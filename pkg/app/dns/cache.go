@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	godns "github.com/miekg/dns"
+)
+
+var (
+	maxCacheTTL     uint32 = 60 * 60
+	maxCacheEntries        = 4096
+)
+
+// cache stores upstream responses until their TTLs expire.
+type cache struct {
+	m       sync.Mutex
+	entries map[cacheKey]*cacheEntry
+	now     func() time.Time
+}
+
+type cacheKey struct {
+	name          string
+	qtype, qclass uint16
+}
+
+type cacheEntry struct {
+	msg       *godns.Msg
+	storedAt  time.Time
+	expiresAt time.Time
+}
+
+func newCache() *cache {
+	return &cache{
+		entries: map[cacheKey]*cacheEntry{},
+		now:     time.Now,
+	}
+}
+
+func newCacheKey(q godns.Question) cacheKey {
+	return cacheKey{name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass}
+}
+
+// Get returns a copy of the cached response whose TTLs are decreased by the elapsed time.
+func (c *cache) Get(q godns.Question) (*godns.Msg, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	key := newCacheKey(q)
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	now := c.now()
+	if !now.Before(e.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	elapsed := uint32(now.Sub(e.storedAt) / time.Second)
+	msg := e.msg.Copy()
+	for _, rrs := range [][]godns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range rrs {
+			if hdr := rr.Header(); hdr.Ttl > elapsed {
+				hdr.Ttl -= elapsed
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
+
+	return msg, true
+}
+
+// Set stores the response. Responses that have no TTL to honor are not stored.
+func (c *cache) Set(q godns.Question, msg *godns.Msg) {
+	ttl, ok := cacheTTL(msg)
+	if !ok {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := c.now()
+
+	if len(c.entries) >= maxCacheEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			return
+		}
+	}
+
+	c.entries[newCacheKey(q)] = &cacheEntry{
+		msg:       msg.Copy(),
+		storedAt:  now,
+		expiresAt: now.Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// cacheTTL returns the shortest TTL in the message.
+// Negative responses are cached for the SOA minimum TTL as described in RFC 2308.
+func cacheTTL(msg *godns.Msg) (ttl uint32, ok bool) {
+	if msg.Truncated || (msg.Rcode != godns.RcodeSuccess && msg.Rcode != godns.RcodeNameError) {
+		return 0, false
+	}
+
+	ttl = maxCacheTTL
+	for _, rrs := range [][]godns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if hdr.Rrtype == godns.TypeOPT {
+				continue
+			}
+			ok = true
+			if hdr.Ttl < ttl {
+				ttl = hdr.Ttl
+			}
+			if soa, isSOA := rr.(*godns.SOA); isSOA && len(msg.Answer) == 0 && soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+		}
+	}
+
+	return ttl, ok && ttl > 0
+}
@@ -0,0 +1,98 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	godns "github.com/miekg/dns"
+)
+
+func newTestMsg(t *testing.T, rcode int, answer, ns []string) *godns.Msg {
+	t.Helper()
+	msg := new(godns.Msg)
+	msg.Rcode = rcode
+	for _, s := range answer {
+		msg.Answer = append(msg.Answer, newTestRR(t, s))
+	}
+	for _, s := range ns {
+		msg.Ns = append(msg.Ns, newTestRR(t, s))
+	}
+	return msg
+}
+
+func newTestRR(t *testing.T, s string) godns.RR {
+	t.Helper()
+	rr, err := godns.NewRR(s)
+	if err != nil {
+		t.Fatalf("NewRR(%q) returned an error: %v", s, err)
+	}
+	return rr
+}
+
+func TestCacheTTL(t *testing.T) {
+	soa := "example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 30"
+
+	cases := []struct {
+		test      string
+		rcode     int
+		answer    []string
+		ns        []string
+		truncated bool
+		ttl       uint32
+		ok        bool
+	}{
+		{test: "answer", answer: []string{"example.com. 300 IN A 192.0.2.1"}, ttl: 300, ok: true},
+		{test: "shortest ttl", answer: []string{"example.com. 300 IN A 192.0.2.1", "example.com. 20 IN A 192.0.2.2"}, ttl: 20, ok: true},
+		{test: "longer than the max", answer: []string{"example.com. 86400 IN A 192.0.2.1"}, ttl: maxCacheTTL, ok: true},
+		{test: "nxdomain", rcode: godns.RcodeNameError, ns: []string{soa}, ttl: 30, ok: true},
+		{test: "nodata", ns: []string{soa}, ttl: 30, ok: true},
+		{test: "zero ttl", answer: []string{"example.com. 0 IN A 192.0.2.1"}},
+		{test: "no records"},
+		{test: "servfail", rcode: godns.RcodeServerFailure, ns: []string{soa}},
+		{test: "truncated", answer: []string{"example.com. 300 IN A 192.0.2.1"}, truncated: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.test, func(t *testing.T) {
+			msg := newTestMsg(t, c.rcode, c.answer, c.ns)
+			msg.Truncated = c.truncated
+			ttl, ok := cacheTTL(msg)
+			if ok != c.ok || (ok && ttl != c.ttl) {
+				t.Errorf("cacheTTL returned (%d, %t), want (%d, %t)", ttl, ok, c.ttl, c.ok)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	c := newCache()
+	c.now = func() time.Time { return now }
+
+	q := godns.Question{Name: "example.com.", Qtype: godns.TypeA, Qclass: godns.ClassINET}
+	c.Set(q, newTestMsg(t, godns.RcodeSuccess, []string{"example.com. 60 IN A 192.0.2.1"}, nil))
+
+	now = now.Add(20 * time.Second)
+	got, ok := c.Get(godns.Question{Name: "EXAMPLE.com.", Qtype: godns.TypeA, Qclass: godns.ClassINET})
+	if !ok {
+		t.Fatal("response should be cached regardless of the case of the name")
+	}
+	if ttl := got.Answer[0].Header().Ttl; ttl != 40 {
+		t.Errorf("TTL is %d, want it to be decreased to 40", ttl)
+	}
+
+	// responses are copied not to be modified by callers
+	got.Answer = nil
+	if got, ok = c.Get(q); !ok || len(got.Answer) != 1 {
+		t.Error("cached response should not be modified")
+	}
+
+	if _, ok = c.Get(godns.Question{Name: "example.com.", Qtype: godns.TypeAAAA, Qclass: godns.ClassINET}); ok {
+		t.Error("response should not be returned for other types")
+	}
+
+	now = now.Add(40 * time.Second)
+	if _, ok = c.Get(q); ok {
+		t.Error("response should expire after the TTL")
+	}
+}
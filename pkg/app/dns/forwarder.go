@@ -0,0 +1,187 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	godns "github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+var (
+	defaultUpstreamPort    = "53"
+	defaultForwardTimeout  = 2 * time.Second
+	defaultForwardUDPSize  = uint16(godns.DefaultMsgSize)
+	defaultForwardNetwork  = "udp"
+	fallbackForwardNetwork = "tcp"
+
+	// interfaceAddrs returns addresses assigned to interfaces of this host, it is replaced in tests.
+	interfaceAddrs = net.InterfaceAddrs
+)
+
+// forwarder resolves queries by delegating them to upstream recursive resolvers.
+type forwarder struct {
+	upstreams []string
+	cache     *cache
+	log       *zap.Logger
+}
+
+func newForwarder(cfg *Config) (*forwarder, error) {
+	upstreams, skipped, err := cfg.upstreams()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	log := zap.L().Named("dns").Named("forwarder")
+	if len(skipped) > 0 {
+		log.Info("skip upstreams pointing to ery itself", zap.Strings("upstreams", skipped))
+		if len(upstreams) == 0 {
+			log.Warn("all upstreams point to ery itself, forwarding is disabled", zap.String("resolv_conf", cfg.ResolvConf))
+		}
+	}
+
+	return &forwarder{
+		upstreams: upstreams,
+		cache:     newCache(),
+		log:       log,
+	}, nil
+}
+
+// Enabled returns true if the forwarder has at least one upstream resolver.
+func (f *forwarder) Enabled() bool {
+	return f != nil && len(f.upstreams) > 0
+}
+
+// Forward resolves the given question with upstream resolvers.
+// Responses are cached until the shortest TTL of their records expires.
+func (f *forwarder) Forward(ctx context.Context, q godns.Question) (*godns.Msg, error) {
+	if resp, ok := f.cache.Get(q); ok {
+		f.log.Debug("cache hit", zap.String("name", q.Name), zap.Uint16("type", q.Qtype))
+		return resp, nil
+	}
+
+	req := new(godns.Msg)
+	req.SetQuestion(q.Name, q.Qtype)
+	req.Question[0].Qclass = q.Qclass
+	req.RecursionDesired = true
+	req.SetEdns0(defaultForwardUDPSize, false)
+
+	var err error
+	for _, upstream := range f.upstreams {
+		var resp *godns.Msg
+		resp, err = f.exchange(ctx, req, upstream)
+		if err != nil {
+			f.log.Debug("failed to forward a query", zap.String("upstream", upstream), zap.String("name", q.Name), zap.Error(err))
+			continue
+		}
+		resp.Extra = withoutOPT(resp.Extra)
+		f.cache.Set(q, resp)
+		return resp, nil
+	}
+
+	return nil, errors.Wrapf(err, "failed to resolve %s with upstreams", q.Name)
+}
+
+func (f *forwarder) exchange(ctx context.Context, req *godns.Msg, upstream string) (*godns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultForwardTimeout)
+	defer cancel()
+
+	cli := &godns.Client{Net: defaultForwardNetwork, UDPSize: defaultForwardUDPSize}
+	resp, _, err := cli.ExchangeContext(ctx, req, upstream)
+	if err == nil && resp.Truncated {
+		cli = &godns.Client{Net: fallbackForwardNetwork}
+		resp, _, err = cli.ExchangeContext(ctx, req, upstream)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if resp.Rcode == godns.RcodeServerFailure || resp.Rcode == godns.RcodeRefused {
+		return nil, errors.Errorf("upstream returned %s", godns.RcodeToString[resp.Rcode])
+	}
+
+	return resp, nil
+}
+
+// upstreams returns addresses of upstream resolvers.
+// Resolvers in resolv.conf that are ery itself are returned as skipped ones.
+func (c *Config) upstreams() (upstreams, skipped []string, err error) {
+	for _, u := range c.Upstreams {
+		addr, err := upstreamAddr(u, defaultUpstreamPort)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		upstreams = append(upstreams, addr)
+	}
+
+	if c.ResolvConf != "" {
+		rc, err := godns.ClientConfigFromFile(c.ResolvConf)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to read %s", c.ResolvConf)
+		}
+		for _, s := range rc.Servers {
+			addr, err := upstreamAddr(s, rc.Port)
+			if err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+			// resolv.conf usually contains ery itself (e.g. "nameserver 127.0.0.1"), forwarding to it causes a loop.
+			if c.isSelf(addr) {
+				skipped = append(skipped, addr)
+				continue
+			}
+			upstreams = append(upstreams, addr)
+		}
+	}
+
+	return upstreams, skipped, nil
+}
+
+// isSelf returns true if the address reaches the DNS server itself.
+// The server listens on all addresses of the port, so it is reached via addresses assigned to interfaces.
+// Other loopback addresses, such as 127.0.0.53 of systemd-resolved and 127.0.1.1 of dnsmasq, are other resolvers.
+func (c *Config) isSelf(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port != strconv.Itoa(int(c.Port)) {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsUnspecified() {
+		return true
+	}
+
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func upstreamAddr(s, defaultPort string) (string, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		return net.JoinHostPort(ip.String(), defaultPort), nil
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return "", errors.Wrapf(err, "invalid upstream address: %q", s)
+	}
+	return s, nil
+}
+
+func withoutOPT(rrs []godns.RR) []godns.RR {
+	out := make([]godns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if _, ok := rr.(*godns.OPT); !ok {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
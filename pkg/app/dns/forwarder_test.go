@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	godns "github.com/miekg/dns"
+)
+
+func TestConfig_isSelf(t *testing.T) {
+	defer func(f func() ([]net.Addr, error)) { interfaceAddrs = f }(interfaceAddrs)
+	interfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+			&net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)},
+		}, nil
+	}
+
+	cfg := &Config{Port: 53}
+
+	cases := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "127.0.0.1:53", want: true},
+		{addr: "[::1]:53", want: true},
+		{addr: "0.0.0.0:53", want: true},
+		{addr: "192.168.1.10:53", want: true},
+		{addr: "127.0.0.1:5353", want: false},
+		{addr: "127.0.0.53:53", want: false}, // systemd-resolved
+		{addr: "127.0.1.1:53", want: false},  // dnsmasq
+		{addr: "8.8.8.8:53", want: false},
+		{addr: "invalid", want: false},
+	}
+
+	for _, c := range cases {
+		if got := cfg.isSelf(c.addr); got != c.want {
+			t.Errorf("isSelf(%q) returned %t, want %t", c.addr, got, c.want)
+		}
+	}
+}
+
+func TestServer_answer_Forward(t *testing.T) {
+	s := newTestServer()
+	// upstreams are never reached because responses are cached
+	s.forwarder.upstreams = []string{"192.0.2.53:53"}
+	s.forwarder.cache.Set(
+		godns.Question{Name: "example.com.", Qtype: godns.TypeA, Qclass: godns.ClassINET},
+		newTestMsg(t, godns.RcodeSuccess, []string{"example.com. 300 IN A 192.0.2.1"}, nil),
+	)
+	s.forwarder.cache.Set(
+		godns.Question{Name: "missing.example.com.", Qtype: godns.TypeA, Qclass: godns.ClassINET},
+		newTestMsg(t, godns.RcodeNameError, nil, []string{"example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 30"}),
+	)
+
+	testAnswer(t, s, []answerCase{
+		{name: "example.com.", qtype: godns.TypeA, answer: []string{"example.com.\t300\tIN\tA\t192.0.2.1"}},
+		{name: "missing.example.com.", qtype: godns.TypeA, rcode: godns.RcodeNameError, ns: []uint16{godns.TypeSOA}},
+		// names of ery are not forwarded
+		{name: "github.com.", qtype: godns.TypeA, answer: []string{"github.com.\t60\tIN\tA\t127.0.0.6"}},
+		{name: "unknown.ery.", qtype: godns.TypeA, rcode: godns.RcodeNameError, ns: []uint16{godns.TypeSOA}},
+		// queries from other hosts are answered only for names of ery
+		{name: "example.com.", qtype: godns.TypeA, rcode: godns.RcodeRefused, remote: true},
+		{name: "github.com.", qtype: godns.TypeA, answer: []string{"github.com.\t60\tIN\tA\t127.0.0.6"}, remote: true},
+		{name: "myapp.ery.", qtype: godns.TypeA, answer: []string{"myapp.ery.\t60\tIN\tA\t127.0.0.5"}, remote: true},
+	})
+}
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	godns "github.com/miekg/dns"
+)
+
+var (
+	reverseZoneV4 = "in-addr.arpa."
+	reverseZoneV6 = "ip6.arpa."
+)
+
+// reverseRecords returns PTR records for addresses allocated to virtual hosts, e.g. "5.0.0.127.in-addr.arpa.".
+func (s *server) reverseRecords(q godns.Question) (rrs []godns.RR, exists bool) {
+	ip, ok := parseReverseName(q.Name)
+	if !ok {
+		return
+	}
+
+	host, ok := s.mappingRepo.LookupHost(context.TODO(), ip)
+	if !ok {
+		return
+	}
+	exists = true
+
+	if q.Qtype == godns.TypePTR || q.Qtype == godns.TypeANY {
+		rrs = append(rrs, &godns.PTR{Hdr: s.header(q.Name, godns.TypePTR), Ptr: godns.Fqdn(host)})
+	}
+
+	return
+}
+
+// parseReverseName is an inverse function of dns.ReverseAddr.
+func parseReverseName(name string) (net.IP, bool) {
+	name = strings.ToLower(godns.Fqdn(name))
+
+	switch {
+	case strings.HasSuffix(name, "."+reverseZoneV4):
+		labels := strings.Split(strings.TrimSuffix(name, "."+reverseZoneV4), ".")
+		if len(labels) != net.IPv4len {
+			return nil, false
+		}
+		ip := make(net.IP, net.IPv4len)
+		for i, l := range labels {
+			b, err := strconv.ParseUint(l, 10, 8)
+			if err != nil {
+				return nil, false
+			}
+			ip[net.IPv4len-1-i] = byte(b)
+		}
+		return ip, true
+	case strings.HasSuffix(name, "."+reverseZoneV6):
+		labels := strings.Split(strings.TrimSuffix(name, "."+reverseZoneV6), ".")
+		if len(labels) != net.IPv6len*2 {
+			return nil, false
+		}
+		ip := make(net.IP, net.IPv6len)
+		for i, l := range labels {
+			n, err := strconv.ParseUint(l, 16, 4)
+			if err != nil || len(l) != 1 {
+				return nil, false
+			}
+			idx := len(labels) - 1 - i
+			if idx%2 == 0 {
+				ip[idx/2] |= byte(n) << 4
+			} else {
+				ip[idx/2] |= byte(n)
+			}
+		}
+		return ip, true
+	}
+
+	return nil, false
+}
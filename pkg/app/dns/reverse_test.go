@@ -0,0 +1,39 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseReverseName(t *testing.T) {
+	cases := []struct {
+		name string
+		want net.IP
+	}{
+		{name: "5.0.0.127.in-addr.arpa.", want: net.ParseIP("127.0.0.5")},
+		{name: "5.0.0.127.IN-ADDR.ARPA", want: net.ParseIP("127.0.0.5")},
+		{name: "7.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.9.7.2.7.5.6.d.f.ip6.arpa.", want: net.ParseIP("fd65:7279::7")},
+		{name: "7.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.9.7.2.7.5.6.D.F.ip6.arpa.", want: net.ParseIP("fd65:7279::7")},
+		{name: "0.0.127.in-addr.arpa."},
+		{name: "256.0.0.127.in-addr.arpa."},
+		{name: "x.0.0.127.in-addr.arpa."},
+		{name: "7.0.ip6.arpa."},
+		{name: "70.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.9.7.2.7.5.6.d.f.ip6.arpa."},
+		{name: "myapp.ery."},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := parseReverseName(c.name)
+			if c.want == nil {
+				if ok {
+					t.Errorf("parseReverseName(%q) should fail, but returned %v", c.name, got)
+				}
+				return
+			}
+			if !ok || !got.Equal(c.want) {
+				t.Errorf("parseReverseName(%q) returned %v, want %v", c.name, got, c.want)
+			}
+		})
+	}
+}
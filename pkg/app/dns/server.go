@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	godns "github.com/miekg/dns"
 	"github.com/pkg/errors"
@@ -14,8 +15,9 @@ import (
 )
 
 var (
-	defaultTTL     uint32 = 60
-	defaultNetwork        = "udp"
+	defaultTTL         uint32 = 60
+	defaultNegativeTTL uint32 = 5
+	defaultNetworks           = []string{"udp", "tcp"}
 )
 
 // Server is an interface of DNS server.
@@ -26,6 +28,12 @@ type Server interface {
 // Config is a configuration object concerning in the DNS server.
 type Config struct {
 	Port domain.Port
+	TLD  string
+
+	// Upstreams is a list of recursive resolvers that queries out of ery's hosts are forwarded to.
+	Upstreams []string
+	// ResolvConf is a path of resolv.conf that upstream resolvers are read from.
+	ResolvConf string
 }
 
 func (c *Config) addr() string {
@@ -44,63 +52,228 @@ func NewServer(mappingRepo domain.MappingRepository, cfg *Config) Server {
 type server struct {
 	*Config
 	mappingRepo domain.MappingRepository
-	server      *godns.Server
+	servers     []*godns.Server
+	forwarder   *forwarder
+	serial      uint32
 	log         *zap.Logger
 }
 
 func (s *server) Serve(ctx context.Context) error {
-	s.server = &godns.Server{
-		Handler: godns.HandlerFunc(s.handle),
-		Addr:    s.addr(),
-		Net:     defaultNetwork,
+	var err error
+	s.forwarder, err = newForwarder(s.Config)
+	if err != nil {
+		return errors.WithStack(err)
 	}
+	s.serial = uint32(time.Now().Unix())
 
-	var err error
-	errCh := make(chan error, 1)
-	go func() {
-		s.log.Info("starting DNS server...", zap.String("addr", s.addr()))
-		errCh <- errors.WithStack(s.server.ListenAndServe())
-	}()
+	errCh := make(chan error, len(defaultNetworks))
+	for _, network := range defaultNetworks {
+		srv := &godns.Server{
+			Handler: godns.HandlerFunc(s.handle),
+			Addr:    s.addr(),
+			Net:     network,
+		}
+		s.servers = append(s.servers, srv)
+		go func(network string) {
+			s.log.Info("starting DNS server...", zap.String("addr", s.addr()), zap.String("network", network), zap.Strings("upstreams", s.forwarder.upstreams))
+			errCh <- errors.WithStack(srv.ListenAndServe())
+		}(network)
+	}
 
 	select {
 	case err = <-errCh:
 		err = errors.WithStack(err)
+		s.log.Info("shutdowning DNS server...", zap.Error(err))
+		s.shutdown()
 	case <-ctx.Done():
 		s.log.Info("shutdowning DNS server...", zap.Error(ctx.Err()))
-		s.server.Shutdown()
+		s.shutdown()
 		err = errors.WithStack(<-errCh)
 	}
 
 	return errors.WithStack(err)
 }
 
+func (s *server) shutdown() {
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(); err != nil {
+			s.log.Debug("failed to shutdown DNS server", zap.String("network", srv.Net), zap.Error(err))
+		}
+	}
+}
+
 func (s *server) handle(w godns.ResponseWriter, req *godns.Msg) {
-	q := req.Question[0]
-	resp := new(godns.Msg)
-	resp.SetReply(req)
+	resp := newReply(req)
+
+	if len(req.Question) == 0 {
+		resp.MsgHdr.Rcode = godns.RcodeFormatError
+		w.WriteMsg(resp)
+		return
+	}
 
-	if ip, ok := s.lookup(q); ok {
-		resp.Answer = append(resp.Answer, &godns.A{
-			Hdr: godns.RR_Header{
-				Name:   q.Name,
-				Rrtype: godns.TypeA,
-				Class:  godns.ClassINET,
-				Ttl:    defaultTTL,
-			},
-			A: ip,
-		})
-	} else {
-		resp.MsgHdr.Rcode = godns.RcodeNameError
+	resp.MsgHdr.Authoritative = true
+	recursive := isLoopback(w.RemoteAddr())
+	for _, q := range req.Question {
+		s.answer(resp, q, recursive)
 	}
 
-	s.log.Debug("received message", zap.Any("req", q), zap.Any("resp", resp))
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		truncate(resp, udpSize(req))
+	}
+
+	s.log.Debug("received message", zap.Any("req", req.Question), zap.Any("resp", resp))
 
 	w.WriteMsg(resp)
 }
 
-func (s *server) lookup(q godns.Question) (ip net.IP, ok bool) {
-	if q.Qtype == godns.TypeA && q.Qclass == godns.ClassINET {
-		ip, ok = s.mappingRepo.LookupIP(context.TODO(), strings.TrimSuffix(q.Name, "."))
+// answer resolves the question and appends its results into the response.
+// Questions out of ery's hosts are forwarded only if recursive is true, otherwise they are refused.
+func (s *server) answer(resp *godns.Msg, q godns.Question, recursive bool) {
+	rrs, exists := s.records(q)
+
+	switch {
+	case len(rrs) > 0:
+		resp.Answer = append(resp.Answer, rrs...)
+		resp.Extra = append(resp.Extra, s.glue(rrs)...)
+	case exists:
+		// NODATA: the name exists but has no records of the type
+		if s.inZone(q.Name) {
+			resp.Ns = append(resp.Ns, s.soa())
+		}
+	case s.shouldForward(q) && !recursive:
+		resp.MsgHdr.Authoritative = false
+		setRcode(resp, godns.RcodeRefused)
+	case s.shouldForward(q):
+		resp.MsgHdr.Authoritative = false
+		s.forward(resp, q)
+	default:
+		if s.inZone(q.Name) {
+			resp.Ns = append(resp.Ns, s.soa())
+		} else {
+			resp.MsgHdr.Authoritative = false
+		}
+		setRcode(resp, godns.RcodeNameError)
+	}
+}
+
+func (s *server) forward(resp *godns.Msg, q godns.Question) {
+	fresp, err := s.forwarder.Forward(context.TODO(), q)
+	if err != nil {
+		s.log.Warn("failed to forward a query", zap.Any("req", q), zap.Error(err))
+		setRcode(resp, godns.RcodeServerFailure)
+		return
+	}
+
+	setRcode(resp, fresp.Rcode)
+	resp.MsgHdr.RecursionAvailable = true
+	resp.Answer = append(resp.Answer, fresp.Answer...)
+	resp.Ns = append(resp.Ns, fresp.Ns...)
+	resp.Extra = append(resp.Extra, fresp.Extra...)
+}
+
+// shouldForward returns true if the question is neither in the ery's TLD nor for registered hosts.
+func (s *server) shouldForward(q godns.Question) bool {
+	return s.forwarder.Enabled() && !s.inZone(q.Name)
+}
+
+// isLoopback returns true if the client is on this host.
+// Queries from other hosts are not forwarded not to make ery an open resolver on the network.
+func isLoopback(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.IsLoopback()
+	case *net.TCPAddr:
+		return a.IP.IsLoopback()
+	}
+	return false
+}
+
+func (s *server) inZone(name string) bool {
+	return s.TLD != "" && godns.IsSubDomain(godns.Fqdn(s.TLD), godns.Fqdn(name))
+}
+
+// records returns resource records for the question.
+// exists reports whether the name is known even if there are no records of the requested type.
+func (s *server) records(q godns.Question) (rrs []godns.RR, exists bool) {
+	if q.Qclass != godns.ClassINET && q.Qclass != godns.ClassANY {
+		return
 	}
+
+	if rrs, exists = s.zoneRecords(q); exists {
+		return
+	}
+	if rrs, exists = s.serviceRecords(q); exists {
+		return
+	}
+	if rrs, exists = s.reverseRecords(q); exists {
+		return
+	}
+	if rrs, exists = s.aliasRecords(q); exists {
+		return
+	}
+
+	host := strings.TrimSuffix(q.Name, ".")
+
+	ip, ok := s.mappingRepo.LookupIP(context.TODO(), host)
+	if !ok {
+		return
+	}
+	exists = true
+
+	if q.Qtype == godns.TypeA || q.Qtype == godns.TypeANY {
+		rrs = append(rrs, &godns.A{Hdr: s.header(q.Name, godns.TypeA), A: ip})
+	}
+	if q.Qtype == godns.TypeAAAA || q.Qtype == godns.TypeANY {
+		if ip, ok = s.mappingRepo.LookupIPv6(context.TODO(), host); ok {
+			rrs = append(rrs, &godns.AAAA{Hdr: s.header(q.Name, godns.TypeAAAA), AAAA: ip})
+		}
+	}
+	rrs = append(rrs, s.txtRecords(q, host)...)
+
 	return
 }
+
+func (s *server) header(name string, rrtype uint16) godns.RR_Header {
+	return godns.RR_Header{
+		Name:   name,
+		Rrtype: rrtype,
+		Class:  godns.ClassINET,
+		Ttl:    defaultTTL,
+	}
+}
+
+// newReply creates a response message for the request.
+// It does not use (*dns.Msg).SetReply because it only handles the first question.
+func newReply(req *godns.Msg) *godns.Msg {
+	resp := new(godns.Msg)
+	resp.Id = req.Id
+	resp.Response = true
+	resp.Opcode = req.Opcode
+	resp.RecursionDesired = req.RecursionDesired
+	resp.CheckingDisabled = req.CheckingDisabled
+	resp.Question = req.Question
+	return resp
+}
+
+// setRcode sets the rcode unless the response has already failed with an other question.
+func setRcode(resp *godns.Msg, rcode int) {
+	if resp.Rcode == godns.RcodeSuccess {
+		resp.Rcode = rcode
+	}
+}
+
+func udpSize(req *godns.Msg) int {
+	if opt := req.IsEdns0(); opt != nil && int(opt.UDPSize()) > godns.MinMsgSize {
+		return int(opt.UDPSize())
+	}
+	return godns.MinMsgSize
+}
+
+// truncate drops records and sets TC bit if the response exceeds the size, then clients retry over TCP.
+func truncate(resp *godns.Msg, size int) {
+	if resp.Len() <= size {
+		return
+	}
+	resp.Truncated = true
+	resp.Answer, resp.Ns, resp.Extra = nil, nil, nil
+}
@@ -0,0 +1,217 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	godns "github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+type fakeMappingRepository struct {
+	domain.MappingRepository
+	mappings []*domain.Mapping
+}
+
+func (r *fakeMappingRepository) Get(ctx context.Context, host string) (*domain.Mapping, bool) {
+	for _, m := range r.mappings {
+		if m.VirtualHost == host {
+			return m, true
+		}
+		for _, alias := range m.Aliases {
+			if alias == host {
+				return m, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (r *fakeMappingRepository) LookupIP(ctx context.Context, host string) (net.IP, bool) {
+	if m, ok := r.Get(ctx, host); ok {
+		return net.ParseIP(m.ProxyHost), true
+	}
+	return nil, false
+}
+
+func (r *fakeMappingRepository) LookupIPv6(ctx context.Context, host string) (net.IP, bool) {
+	if m, ok := r.Get(ctx, host); ok && m.ProxyHostV6 != "" {
+		return net.ParseIP(m.ProxyHostV6), true
+	}
+	return nil, false
+}
+
+func (r *fakeMappingRepository) LookupHost(ctx context.Context, ip net.IP) (string, bool) {
+	for _, m := range r.mappings {
+		if ip.Equal(net.ParseIP(m.ProxyHost)) || ip.Equal(net.ParseIP(m.ProxyHostV6)) {
+			return m.VirtualHost, true
+		}
+	}
+	return "", false
+}
+
+func newTestServer() *server {
+	return &server{
+		Config: &Config{TLD: "ery"},
+		mappingRepo: &fakeMappingRepository{mappings: []*domain.Mapping{
+			{
+				VirtualHost: "myapp.ery",
+				ProxyHost:   "127.0.0.5",
+				PortMap:     domain.PortMap{80: 3000},
+				Aliases:     []string{"www.myapp.ery", "myapp.example.com"},
+				Meta:        map[string]string{"container_id": "c0ffee", "compose_service": "web"},
+			},
+			{
+				VirtualHost: "dns.ery",
+				ProxyHost:   "127.0.0.7",
+				ProxyHostV6: "fd65:7279::7",
+				PortMap:     domain.PortMap{53: 5353},
+				Protocols:   map[domain.Port]domain.Protocol{53: domain.ProtocolUDP},
+			},
+			{VirtualHost: "github.com", ProxyHost: "127.0.0.6", PortMap: domain.PortMap{80: 3001}},
+		}},
+		forwarder: &forwarder{cache: newCache(), log: zap.NewNop()},
+		serial:    1,
+		log:       zap.NewNop(),
+	}
+}
+
+// answerCase is a question and its expected response. Records are compared in the presentation format.
+type answerCase struct {
+	name   string
+	qtype  uint16
+	rcode  int
+	answer []string
+	ns     []uint16
+	extra  []string
+	remote bool // sent from other hosts
+}
+
+func testAnswer(t *testing.T, s *server, cases []answerCase) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(godns.TypeToString[c.qtype]+" "+c.name, func(t *testing.T) {
+			req := new(godns.Msg)
+			req.SetQuestion(c.name, c.qtype)
+			resp := newReply(req)
+			s.answer(resp, req.Question[0], !c.remote)
+
+			if resp.Rcode != c.rcode {
+				t.Errorf("rcode is %s, want %s", godns.RcodeToString[resp.Rcode], godns.RcodeToString[c.rcode])
+			}
+			if got := rrStrings(resp.Answer); !reflect.DeepEqual(got, c.answer) {
+				t.Errorf("answer section is %q, want %q", got, c.answer)
+			}
+			if got := rrTypes(resp.Ns); !reflect.DeepEqual(got, c.ns) {
+				t.Errorf("authority section has %v, want %v", got, c.ns)
+			}
+			if got := rrStrings(resp.Extra); !reflect.DeepEqual(got, c.extra) {
+				t.Errorf("additional section is %q, want %q", got, c.extra)
+			}
+		})
+	}
+}
+
+func rrStrings(rrs []godns.RR) (out []string) {
+	for _, rr := range rrs {
+		out = append(out, rr.String())
+	}
+	return
+}
+
+func rrTypes(rrs []godns.RR) (out []uint16) {
+	for _, rr := range rrs {
+		out = append(out, rr.Header().Rrtype)
+	}
+	return
+}
+
+func TestServer_answer(t *testing.T) {
+	testAnswer(t, newTestServer(), []answerCase{
+		{name: "myapp.ery.", qtype: godns.TypeA, answer: []string{"myapp.ery.\t60\tIN\tA\t127.0.0.5"}},
+		{name: "myapp.ery.", qtype: godns.TypeAAAA, ns: []uint16{godns.TypeSOA}},
+		{name: "myapp.ery.", qtype: godns.TypeMX, ns: []uint16{godns.TypeSOA}},
+		{name: "unknown.ery.", qtype: godns.TypeA, rcode: godns.RcodeNameError, ns: []uint16{godns.TypeSOA}},
+		{name: "unknown.ery.", qtype: godns.TypeAAAA, rcode: godns.RcodeNameError, ns: []uint16{godns.TypeSOA}},
+		{name: "github.com.", qtype: godns.TypeA, answer: []string{"github.com.\t60\tIN\tA\t127.0.0.6"}},
+		{name: "github.com.", qtype: godns.TypeMX},
+		{name: "example.com.", qtype: godns.TypeA, rcode: godns.RcodeNameError},
+		{name: "ery.", qtype: godns.TypeSOA, answer: []string{"ery.\t60\tIN\tSOA\tns.ery. hostmaster.ery. 1 3600 600 86400 5"}},
+		{name: "ery.", qtype: godns.TypeNS, answer: []string{"ery.\t60\tIN\tNS\tns.ery."}},
+		{name: "ery.", qtype: godns.TypeA, ns: []uint16{godns.TypeSOA}},
+		{name: "ns.ery.", qtype: godns.TypeA, answer: []string{"ns.ery.\t60\tIN\tA\t127.0.0.1"}},
+		{name: "ns.ery.", qtype: godns.TypeAAAA, answer: []string{"ns.ery.\t60\tIN\tAAAA\t::1"}},
+	})
+}
+
+func TestServer_answer_Service(t *testing.T) {
+	testAnswer(t, newTestServer(), []answerCase{
+		{
+			name:   "_http._tcp.myapp.ery.",
+			qtype:  godns.TypeSRV,
+			answer: []string{"_http._tcp.myapp.ery.\t60\tIN\tSRV\t0 0 80 myapp.ery."},
+			extra:  []string{"myapp.ery.\t60\tIN\tA\t127.0.0.5"},
+		},
+		{
+			name:   "_80._tcp.myapp.ery.",
+			qtype:  godns.TypeSRV,
+			answer: []string{"_80._tcp.myapp.ery.\t60\tIN\tSRV\t0 0 80 myapp.ery."},
+			extra:  []string{"myapp.ery.\t60\tIN\tA\t127.0.0.5"},
+		},
+		{name: "_http._tcp.myapp.ery.", qtype: godns.TypeA, ns: []uint16{godns.TypeSOA}},
+		{name: "_8080._tcp.myapp.ery.", qtype: godns.TypeSRV, rcode: godns.RcodeNameError, ns: []uint16{godns.TypeSOA}},
+		{name: "_http._udp.myapp.ery.", qtype: godns.TypeSRV, rcode: godns.RcodeNameError, ns: []uint16{godns.TypeSOA}},
+		{
+			name:   "_53._udp.dns.ery.",
+			qtype:  godns.TypeSRV,
+			answer: []string{"_53._udp.dns.ery.\t60\tIN\tSRV\t0 0 53 dns.ery."},
+			extra:  []string{"dns.ery.\t60\tIN\tA\t127.0.0.7", "dns.ery.\t60\tIN\tAAAA\tfd65:7279::7"},
+		},
+		{name: "_53._tcp.dns.ery.", qtype: godns.TypeSRV, rcode: godns.RcodeNameError, ns: []uint16{godns.TypeSOA}},
+		{
+			name:   "myapp.ery.",
+			qtype:  godns.TypeTXT,
+			answer: []string{"myapp.ery.\t60\tIN\tTXT\t\"compose_service=web\" \"container_id=c0ffee\""},
+		},
+		{name: "dns.ery.", qtype: godns.TypeTXT, ns: []uint16{godns.TypeSOA}},
+	})
+}
+
+func TestServer_answer_Reverse(t *testing.T) {
+	reverseAddr := func(ip string) string {
+		name, err := godns.ReverseAddr(ip)
+		if err != nil {
+			t.Fatalf("ReverseAddr returned an error: %v", err)
+		}
+		return name
+	}
+
+	testAnswer(t, newTestServer(), []answerCase{
+		{name: "5.0.0.127.in-addr.arpa.", qtype: godns.TypePTR, answer: []string{"5.0.0.127.in-addr.arpa.\t60\tIN\tPTR\tmyapp.ery."}},
+		{name: "5.0.0.127.in-addr.arpa.", qtype: godns.TypeA},
+		{name: "9.0.0.127.in-addr.arpa.", qtype: godns.TypePTR, rcode: godns.RcodeNameError},
+		{name: reverseAddr("fd65:7279::7"), qtype: godns.TypePTR, answer: []string{reverseAddr("fd65:7279::7") + "\t60\tIN\tPTR\tdns.ery."}},
+	})
+}
+
+func TestServer_answer_Alias(t *testing.T) {
+	testAnswer(t, newTestServer(), []answerCase{
+		{
+			name:   "www.myapp.ery.",
+			qtype:  godns.TypeA,
+			answer: []string{"www.myapp.ery.\t60\tIN\tCNAME\tmyapp.ery.", "myapp.ery.\t60\tIN\tA\t127.0.0.5"},
+		},
+		{name: "www.myapp.ery.", qtype: godns.TypeCNAME, answer: []string{"www.myapp.ery.\t60\tIN\tCNAME\tmyapp.ery."}},
+		{name: "www.myapp.ery.", qtype: godns.TypeAAAA, answer: []string{"www.myapp.ery.\t60\tIN\tCNAME\tmyapp.ery."}},
+		{
+			name:   "myapp.example.com.",
+			qtype:  godns.TypeA,
+			answer: []string{"myapp.example.com.\t60\tIN\tCNAME\tmyapp.ery.", "myapp.ery.\t60\tIN\tA\t127.0.0.5"},
+		},
+		{name: "myapp.ery.", qtype: godns.TypeCNAME, ns: []uint16{godns.TypeSOA}},
+	})
+}
@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	godns "github.com/miekg/dns"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+var (
+	serviceProtoTCP = "_tcp"
+	serviceProtoUDP = "_udp"
+	// servicePorts are well-known ports of service names, other services are queried with port numbers (e.g. "_8080._tcp").
+	// They are not looked up from the system services database, not to read it on every query.
+	servicePorts = map[string]domain.Port{
+		"ssh":        22,
+		"smtp":       25,
+		"domain":     53,
+		"dns":        53,
+		"http":       80,
+		"ntp":        123,
+		"imap":       143,
+		"ldap":       389,
+		"https":      443,
+		"submission": 587,
+		"ms-sql-s":   1433,
+		"mqtt":       1883,
+		"mysql":      3306,
+		"postgresql": 5432,
+		"amqp":       5672,
+		"redis":      6379,
+		"cql":        9042,
+		"memcache":   11211,
+		"mongodb":    27017,
+		"grpc":       50051,
+	}
+)
+
+// serviceRecords returns SRV records for names like "_http._tcp.myapp.ery" and "_8080._tcp.myapp.ery".
+// Their targets are virtual hosts and ports are virtual ports of mappings.
+// Ports relayed over UDP are answered for "_udp" names instead.
+func (s *server) serviceRecords(q godns.Question) (rrs []godns.RR, exists bool) {
+	port, udp, host, ok := parseServiceName(q.Name)
+	if !ok {
+		return
+	}
+
+	m, ok := s.mappingRepo.Get(context.TODO(), host)
+	if !ok {
+		return
+	}
+	if _, ok = m.PortMap[port]; !ok || udp != (m.Protocol(port) == domain.ProtocolUDP) {
+		return
+	}
+	exists = true
+
+	if q.Qtype == godns.TypeSRV || q.Qtype == godns.TypeANY {
+		rrs = append(rrs, &godns.SRV{
+			Hdr:    s.header(q.Name, godns.TypeSRV),
+			Port:   uint16(port),
+			Target: godns.Fqdn(host),
+		})
+	}
+
+	return
+}
+
+// txtRecords returns a TXT record containing metadata of the mapping, such as "container_id=...".
+func (s *server) txtRecords(q godns.Question, host string) (rrs []godns.RR) {
+	if q.Qtype != godns.TypeTXT && q.Qtype != godns.TypeANY {
+		return
+	}
+
+	m, ok := s.mappingRepo.Get(context.TODO(), host)
+	if !ok || len(m.Meta) == 0 {
+		return
+	}
+
+	txt := make([]string, 0, len(m.Meta))
+	for k, v := range m.Meta {
+		txt = append(txt, k+"="+v)
+	}
+	sort.Strings(txt)
+
+	return append(rrs, &godns.TXT{Hdr: s.header(q.Name, godns.TypeTXT), Txt: txt})
+}
+
+// glue returns address records for targets of SRV records to put them in the additional section.
+func (s *server) glue(rrs []godns.RR) (extra []godns.RR) {
+	for _, rr := range rrs {
+		srv, ok := rr.(*godns.SRV)
+		if !ok {
+			continue
+		}
+		for _, t := range []uint16{godns.TypeA, godns.TypeAAAA} {
+			got, _ := s.records(godns.Question{Name: srv.Target, Qtype: t, Qclass: godns.ClassINET})
+			extra = append(extra, got...)
+		}
+	}
+	return
+}
+
+func parseServiceName(name string) (port domain.Port, udp bool, host string, ok bool) {
+	labels := godns.SplitDomainName(name)
+	if len(labels) < 3 || !strings.HasPrefix(labels[0], "_") {
+		return
+	}
+	switch strings.ToLower(labels[1]) {
+	case serviceProtoTCP:
+	case serviceProtoUDP:
+		udp = true
+	default:
+		return
+	}
+
+	service := strings.ToLower(strings.TrimPrefix(labels[0], "_"))
+	host = strings.Join(labels[2:], ".")
+
+	if p, err := domain.PortFromString(service); err == nil {
+		return p, udp, host, true
+	}
+	if p, found := servicePorts[service]; found {
+		return p, udp, host, true
+	}
+
+	return
+}
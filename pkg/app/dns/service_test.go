@@ -0,0 +1,39 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+func TestParseServiceName(t *testing.T) {
+	cases := []struct {
+		name string
+		port domain.Port
+		udp  bool
+		host string
+		ok   bool
+	}{
+		{name: "_http._tcp.myapp.ery.", port: 80, host: "myapp.ery", ok: true},
+		{name: "_HTTPS._TCP.myapp.ery.", port: 443, host: "myapp.ery", ok: true},
+		{name: "_8080._tcp.myapp.ery.", port: 8080, host: "myapp.ery", ok: true},
+		{name: "_domain._udp.dns.ery.", port: 53, udp: true, host: "dns.ery", ok: true},
+		{name: "_postgresql._tcp.db.myapp.ery.", port: 5432, host: "db.myapp.ery", ok: true},
+		{name: "_unknown._tcp.myapp.ery."},
+		{name: "_http._sctp.myapp.ery."},
+		{name: "http._tcp.myapp.ery."},
+		{name: "_http._tcp."},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			port, udp, host, ok := parseServiceName(c.name)
+			if ok != c.ok {
+				t.Fatalf("parseServiceName(%q) returned ok=%t, want %t", c.name, ok, c.ok)
+			}
+			if ok && (port != c.port || udp != c.udp || host != c.host) {
+				t.Errorf("parseServiceName(%q) returned (%d, %t, %q), want (%d, %t, %q)", c.name, port, udp, host, c.port, c.udp, c.host)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	godns "github.com/miekg/dns"
+)
+
+var (
+	nsIPs = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+)
+
+// zoneRecords returns records for the apex of the ery's TLD and its nameserver.
+func (s *server) zoneRecords(q godns.Question) (rrs []godns.RR, exists bool) {
+	if s.TLD == "" {
+		return
+	}
+
+	name := strings.ToLower(godns.Fqdn(q.Name))
+
+	switch name {
+	case s.zone():
+		exists = true
+		if q.Qtype == godns.TypeSOA || q.Qtype == godns.TypeANY {
+			rrs = append(rrs, s.soa())
+		}
+		if q.Qtype == godns.TypeNS || q.Qtype == godns.TypeANY {
+			rrs = append(rrs, &godns.NS{Hdr: s.header(s.zone(), godns.TypeNS), Ns: s.nameserver()})
+		}
+	case s.nameserver():
+		exists = true
+		for _, ip := range nsIPs {
+			if v4 := ip.To4(); v4 != nil && (q.Qtype == godns.TypeA || q.Qtype == godns.TypeANY) {
+				rrs = append(rrs, &godns.A{Hdr: s.header(q.Name, godns.TypeA), A: v4})
+			}
+			if ip.To4() == nil && (q.Qtype == godns.TypeAAAA || q.Qtype == godns.TypeANY) {
+				rrs = append(rrs, &godns.AAAA{Hdr: s.header(q.Name, godns.TypeAAAA), AAAA: ip})
+			}
+		}
+	}
+
+	return
+}
+
+func (s *server) zone() string {
+	return strings.ToLower(godns.Fqdn(s.TLD))
+}
+
+func (s *server) nameserver() string {
+	return "ns." + s.zone()
+}
+
+// soa returns the SOA record of the ery's TLD.
+// Its minimum TTL is used by resolvers as TTL of negative responses (RFC 2308).
+func (s *server) soa() godns.RR {
+	return &godns.SOA{
+		Hdr:     s.header(s.zone(), godns.TypeSOA),
+		Ns:      s.nameserver(),
+		Mbox:    "hostmaster." + s.zone(),
+		Serial:  s.serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  defaultNegativeTTL,
+	}
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+// Reasons of routeError.
+const (
+	reasonInvalidHost         = "invalid_host"
+	reasonUnknownHost         = "unknown_host"
+	reasonPortNotMapped       = "port_not_mapped"
+	reasonRouteTargetNotFound = "route_target_not_found"
+	reasonStarting            = "starting"
+	reasonBackendRefused      = "backend_refused"
+	reasonBackendTimedOut     = "backend_timed_out"
+	reasonBackendError        = "backend_error"
+)
+
+// routeError represents a reason why the proxy cannot route a request to the target.
+type routeError struct {
+	Code    int               `json:"-"`
+	Reason  string            `json:"error"`
+	Title   string            `json:"-"`
+	Message string            `json:"message"`
+	Hosts   []*registeredHost `json:"registered_hosts"`
+}
+
+// registeredHost is a virtual host listed in error pages.
+type registeredHost struct {
+	Host  string        `json:"host"`
+	Ports []domain.Port `json:"ports"`
+}
+
+// newBackendError returns a routeError for errors on proxying requests to the target.
+func newBackendError(target string, err error) *routeError {
+	cause := errors.Cause(err)
+	if ne, ok := cause.(net.Error); (ok && ne.Timeout()) || cause == context.DeadlineExceeded {
+		return &routeError{
+			Code:    http.StatusGatewayTimeout,
+			Reason:  reasonBackendTimedOut,
+			Title:   "Backend timed out",
+			Message: target + " did not respond in time.",
+		}
+	}
+	if isConnRefused(cause) {
+		return &routeError{
+			Code:    http.StatusBadGateway,
+			Reason:  reasonBackendRefused,
+			Title:   "Backend refused connection",
+			Message: target + " refused the connection. Is the server running and listening on the port?",
+		}
+	}
+	return &routeError{
+		Code:    http.StatusBadGateway,
+		Reason:  reasonBackendError,
+		Title:   "Bad gateway",
+		Message: "Failed to proxy the request to " + target + ": " + cause.Error(),
+	}
+}
+
+func isConnRefused(err error) bool {
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
+
+// writeRouteError responds the error as JSON if clients accept it, or as an HTML page otherwise.
+func (s *server) writeRouteError(w http.ResponseWriter, req *http.Request, e *routeError) {
+	mappings, err := s.mappingRepo.List(req.Context())
+	if err == nil {
+		e.Hosts = registeredHosts(mappings)
+	}
+
+	if e.Code == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+
+	if strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(e.Code)
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(e.Code)
+	errorPageTmpl.Execute(w, e)
+}
+
+// registeredHosts returns virtual hosts and aliases of the mappings in order of names.
+func registeredHosts(mappings []*domain.Mapping) []*registeredHost {
+	hosts := []*registeredHost{}
+	for _, m := range mappings {
+		ports := make([]domain.Port, 0, len(m.PortMap))
+		for p := range m.PortMap {
+			ports = append(ports, p)
+		}
+		sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+		for _, h := range append([]string{m.VirtualHost}, m.Aliases...) {
+			hosts = append(hosts, &registeredHost{Host: h, Ports: ports})
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+var errorPageTmpl = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}} - ery</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+<h2>Registered hosts</h2>
+{{- if .Hosts}}
+<ul>
+{{- range .Hosts}}
+<li>{{.Host}} ({{range $i, $p := .Ports}}{{if $i}}, {{end}}{{$p}}{{end}})</li>
+{{- end}}
+</ul>
+{{- else}}
+<p>No hosts are registered.</p>
+{{- end}}
+</body>
+</html>
+`))
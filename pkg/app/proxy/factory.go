@@ -6,20 +6,41 @@ import (
 
 // ServerFactory is a factory object for creating proxy server instances.
 type ServerFactory interface {
-	CreateServer(addr domain.Addr) Server
+	CreateServer(addr domain.Addr, protocol domain.Protocol) Server
+}
+
+// Config is a configuration object concerning in proxy servers.
+type Config struct {
+	// TLD is a top level domain of virtual hosts. Servers on the HTTPS port serve certificates only for its subdomains.
+	TLD string
+	// ExternalRouteHosts are hosts outside ery that routes can forward requests to, such as "localhost".
+	// Routes to other unregistered hosts are refused, so that mappings cannot make proxies connect to arbitrary hosts.
+	ExternalRouteHosts []string
 }
 
 // NewFactory creates a new ServerFactory instance.
-func NewFactory(mappingRepo domain.MappingRepository) ServerFactory {
+// Servers on the HTTPS port terminate TLS with certificates from the repository.
+func NewFactory(mappingRepo domain.MappingRepository, certRepo domain.CertificateRepository, cfg *Config) ServerFactory {
 	return &serverFactory{
+		Config:      cfg,
 		mappingRepo: mappingRepo,
+		certRepo:    certRepo,
 	}
 }
 
 type serverFactory struct {
+	*Config
 	mappingRepo domain.MappingRepository
+	certRepo    domain.CertificateRepository
 }
 
-func (f *serverFactory) CreateServer(addr domain.Addr) Server {
-	return newServerWithPort(f.mappingRepo, addr)
+func (f *serverFactory) CreateServer(addr domain.Addr, protocol domain.Protocol) Server {
+	switch protocol {
+	case domain.ProtocolTCP:
+		return newTCPServer(f.mappingRepo, addr)
+	case domain.ProtocolUDP:
+		return newUDPServer(f.mappingRepo, addr)
+	default:
+		return newServerWithPort(f.mappingRepo, f.certRepo, f.Config, addr)
+	}
 }
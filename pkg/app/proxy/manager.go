@@ -44,6 +44,15 @@ func (m *serverManager) ListenMappingEvents(ctx context.Context) error {
 		m.cancellers = cancellers{}
 	}()
 
+	// start servers for mappings that have been registered before listening, e.g. restored ones
+	mappings, err := m.mappingRepo.List(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, mapping := range mappings {
+		m.handleCreated(ctx, wg, domain.MappingEvent{Type: domain.MappingEventCreated, Mapping: *mapping})
+	}
+
 	for {
 		select {
 		case ev := <-evCh:
@@ -53,6 +62,8 @@ func (m *serverManager) ListenMappingEvents(ctx context.Context) error {
 				m.handleCreated(ctx, wg, ev)
 			case domain.MappingEventDestroyed:
 				m.handleDestroyed(ctx, wg, ev)
+			case domain.MappingEventUpdated:
+				// ports are unchanged, servers look up mappings on each request
 			}
 		case err := <-errCh:
 			return errors.WithStack(err)
@@ -68,26 +79,23 @@ func (m *serverManager) ListenMappingEvents(ctx context.Context) error {
 }
 
 func (m *serverManager) handleCreated(ctx context.Context, wg *sync.WaitGroup, ev domain.MappingEvent) {
-	for cport := range ev.PortMap {
-		addr := domain.Addr{Host: ev.ProxyHost, Port: cport}
-		if c, ok := m.cancellers.Get(addr); ok {
-			c.Add(1)
-		} else {
+	for _, addr := range proxyAddrs(&ev.Mapping) {
+		// a created event contains all ports of the mapping, servers for registered ports are already running
+		if _, ok := m.cancellers.Get(addr); !ok {
 			wg.Add(1)
 			c, cctx := cancellerWithContext(ctx)
 			c.Add(1)
 			m.cancellers.Set(addr, c)
-			go func(ctx context.Context, addr domain.Addr) {
+			go func(ctx context.Context, addr domain.Addr, protocol domain.Protocol) {
 				defer wg.Done()
-				m.factory.CreateServer(addr).Serve(ctx)
-			}(cctx, addr)
+				m.factory.CreateServer(addr, protocol).Serve(ctx)
+			}(cctx, addr, ev.Protocol(addr.Port))
 		}
 	}
 }
 
 func (m *serverManager) handleDestroyed(ctx context.Context, wg *sync.WaitGroup, ev domain.MappingEvent) {
-	for cport := range ev.PortMap {
-		addr := domain.Addr{Host: ev.ProxyHost, Port: cport}
+	for _, addr := range proxyAddrs(&ev.Mapping) {
 		if c, ok := m.cancellers.Get(addr); ok {
 			c.Done()
 			if c.count == 0 {
@@ -97,6 +105,25 @@ func (m *serverManager) handleDestroyed(ctx context.Context, wg *sync.WaitGroup,
 	}
 }
 
+// proxyAddrs returns addresses that proxy servers for the mapping should listen on.
+// Mappings having the HTTP port are also served over TLS on the HTTPS port.
+func proxyAddrs(m *domain.Mapping) []domain.Addr {
+	addrs := m.ProxyAddrs()
+	httpPort := domain.HTTPAddr("").Port
+	if _, ok := m.PortMap[httpsPort]; ok {
+		return addrs
+	}
+	if _, ok := m.PortMap[httpPort]; !ok {
+		return addrs
+	}
+	for _, host := range []string{m.ProxyHost, m.ProxyHostV6} {
+		if host != "" {
+			addrs = append(addrs, domain.Addr{Host: host, Port: httpsPort})
+		}
+	}
+	return addrs
+}
+
 type cancellers struct {
 	byAddr sync.Map
 }
@@ -2,7 +2,9 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"strings"
@@ -16,6 +18,8 @@ import (
 
 var (
 	defaultScheme = "http"
+	// httpsPort is a port that proxy servers serve over TLS.
+	httpsPort = domain.Port(443)
 )
 
 // Server is an interface of Proxy server.
@@ -23,16 +27,20 @@ type Server interface {
 	Serve(context.Context) error
 }
 
-func newServerWithPort(mappingRepo domain.MappingRepository, addr domain.Addr) Server {
+func newServerWithPort(mappingRepo domain.MappingRepository, certRepo domain.CertificateRepository, cfg *Config, addr domain.Addr) Server {
 	return &server{
+		Config:      cfg,
 		mappingRepo: mappingRepo,
+		certRepo:    certRepo,
 		addr:        addr,
 		log:         zap.L().Named("proxy"),
 	}
 }
 
 type server struct {
+	*Config
 	mappingRepo domain.MappingRepository
+	certRepo    domain.CertificateRepository
 	server      *http.Server
 	addr        domain.Addr
 	log         *zap.Logger
@@ -41,13 +49,26 @@ type server struct {
 func (s *server) Serve(ctx context.Context) error {
 	s.server = &http.Server{
 		Addr:    s.addr.String(),
-		Handler: &httputil.ReverseProxy{Director: s.handle},
+		Handler: s.createHandler(),
+	}
+
+	useTLS := s.addr.Port == httpsPort
+	if useTLS {
+		s.server.TLSConfig = &tls.Config{
+			GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return s.getCertificate(ctx, hello)
+			},
+		}
 	}
 
 	var err error
 	errCh := make(chan error, 1)
 	go func() {
-		s.log.Info("starting proxy server...", zap.Stringer("addr", &s.addr))
+		s.log.Info("starting proxy server...", zap.Stringer("addr", &s.addr), zap.Bool("tls", useTLS))
+		if useTLS {
+			errCh <- errors.WithStack(s.server.ListenAndServeTLS("", ""))
+			return
+		}
 		errCh <- errors.WithStack(s.server.ListenAndServe())
 	}()
 
@@ -64,27 +85,193 @@ func (s *server) Serve(ctx context.Context) error {
 	return errors.WithStack(err)
 }
 
-func (s *server) handle(req *http.Request) {
-	req.URL.Scheme = defaultScheme
+// getCertificate returns a certificate for the server name, or the host of the proxy address if clients don't send it.
+// Certificates are issued only for registered hosts under the TLD, any process can register hosts such as "github.com".
+func (s *server) getCertificate(ctx context.Context, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := hello.ServerName
+	if host == "" {
+		var ok bool
+		host, ok = s.mappingRepo.LookupHost(ctx, net.ParseIP(s.addr.Host))
+		if !ok {
+			return nil, errors.Errorf("no hosts are registered for %s", s.addr.Host)
+		}
+	}
+	if !domain.IsCertifiable(host, s.TLD) {
+		return nil, errors.Errorf("%s is not a subdomain of %s", host, s.TLD)
+	}
+	if _, ok := s.mappingRepo.Get(ctx, host); !ok {
+		return nil, errors.Errorf("%s is not registered", host)
+	}
 
-	hostAndPort := strings.SplitN(req.Host, ":", 2)
-	addr := domain.HTTPAddr(hostAndPort[0])
-	if len(hostAndPort) == 2 {
-		var err error
-		addr.Port, err = domain.PortFromString(hostAndPort[1])
-		if err != nil {
+	cert, err := s.certRepo.GetCertificate(ctx, host)
+	if err != nil {
+		s.log.Warn("failed to get a certificate", zap.String("host", host), zap.Error(err))
+		return nil, errors.WithStack(err)
+	}
+	return cert, nil
+}
+
+// targetKey is a context key of the target resolved before proxying.
+type targetKey struct{}
+
+// target is a destination of a request.
+type target struct {
+	addr  domain.Addr
+	route *domain.Route // nil unless a route matches the path
+}
+
+func (s *server) createHandler() http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Director: s.handle,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			s.log.Debug("failed to proxy a request", zap.Error(err), zap.String("host", req.Host), zap.String("target", req.URL.Host))
+			s.writeRouteError(w, req, newBackendError(req.URL.Host, err))
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t, rerr := s.resolve(req)
+		if rerr != nil {
+			s.log.Debug("failed to route a request", zap.String("host", req.Host), zap.String("reason", rerr.Reason))
+			s.writeRouteError(w, req, rerr)
 			return
 		}
+		proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), targetKey{}, t)))
+	})
+}
+
+// resolve returns the target that the request should be proxied to.
+// Routes of the mapping take precedence over its port map.
+func (s *server) resolve(req *http.Request) (*target, *routeError) {
+	addr, ok := requestAddr(req)
+	if !ok {
+		return nil, &routeError{
+			Code:    http.StatusBadRequest,
+			Reason:  reasonInvalidHost,
+			Title:   "Invalid host",
+			Message: req.Host + " is not a valid host.",
+		}
+	}
+
+	m, ok := s.mappingRepo.Get(req.Context(), addr.Host)
+	if !ok {
+		return nil, &routeError{
+			Code:    http.StatusNotFound,
+			Reason:  reasonUnknownHost,
+			Title:   "Unknown host",
+			Message: addr.Host + " is not registered to ery.",
+		}
+	}
+	if m.Status == domain.MappingStatusStarting {
+		return nil, &routeError{
+			Code:    http.StatusServiceUnavailable,
+			Reason:  reasonStarting,
+			Title:   "Service starting",
+			Message: addr.Host + " is starting, please retry in a moment.",
+		}
+	}
+
+	if route, ok := m.Routes.Match(req.URL.Path); ok {
+		return s.resolveRoute(req, addr, route)
 	}
 
 	outAddr, err := s.mappingRepo.MapAddr(req.Context(), addr)
+	if err != nil && req.TLS != nil && addr.Port == httpsPort {
+		// mappings without the HTTPS port are served over TLS via the HTTP port
+		addr.Port = domain.HTTPAddr("").Port
+		outAddr, err = s.mappingRepo.MapAddr(req.Context(), addr)
+	}
 	if err != nil {
-		return
+		return nil, &routeError{
+			Code:    http.StatusMisdirectedRequest,
+			Reason:  reasonPortNotMapped,
+			Title:   "Port not mapped",
+			Message: fmt.Sprintf("%s is registered, but the port %d is not mapped.", addr.Host, addr.Port),
+		}
 	}
 	if outAddr.Host == "" {
 		outAddr.Host = s.localhost()
 	}
-	req.URL.Host = fmt.Sprintf("%s:%d", outAddr.Host, outAddr.Port)
+
+	return &target{addr: outAddr}, nil
+}
+
+// resolveRoute returns the target of the route.
+// Targets are registered virtual hosts, or external hosts allowed in the config (e.g. "localhost:3000") that are used as is.
+func (s *server) resolveRoute(req *http.Request, addr domain.Addr, route *domain.Route) (*target, *routeError) {
+	routeAddr := route.Target
+	if routeAddr.Host == "" {
+		routeAddr.Host = addr.Host
+	}
+	if s.isExternalRouteHost(routeAddr.Host) {
+		return &target{addr: routeAddr, route: route}, nil
+	}
+
+	outAddr, err := s.mappingRepo.MapAddr(req.Context(), routeAddr)
+	if err != nil {
+		msg := fmt.Sprintf("%s is routed to %s, but it is not registered.", route.PathPrefix, routeAddr.String())
+		if _, registered := s.mappingRepo.Get(req.Context(), routeAddr.Host); registered {
+			msg = fmt.Sprintf("%s is routed to %s, but the port %d is not mapped.", route.PathPrefix, routeAddr.Host, routeAddr.Port)
+		} else if !domain.IsSubdomain(routeAddr.Host, s.TLD) {
+			msg = fmt.Sprintf("%s is routed to %s, but it is neither registered nor allowed as an external host.", route.PathPrefix, routeAddr.String())
+		}
+		return nil, &routeError{
+			Code:    http.StatusBadGateway,
+			Reason:  reasonRouteTargetNotFound,
+			Title:   "Route target not found",
+			Message: msg,
+		}
+	}
+	if outAddr.Host == "" {
+		outAddr.Host = s.localhost()
+	}
+
+	return &target{addr: outAddr, route: route}, nil
+}
+
+// isExternalRouteHost returns true if routes can forward requests to the host directly.
+func (s *server) isExternalRouteHost(host string) bool {
+	for _, h := range s.ExternalRouteHosts {
+		if strings.EqualFold(strings.Trim(h, "[]"), host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) handle(req *http.Request) {
+	req.URL.Scheme = defaultScheme
+
+	if t, ok := req.Context().Value(targetKey{}).(*target); ok {
+		req.URL.Host = t.addr.String()
+		if t.route != nil && t.route.StripPrefix {
+			req.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(t.route.PathPrefix, "/"))
+			req.URL.Path = t.route.Rewrite(req.URL.Path)
+			if req.URL.RawPath != "" {
+				req.URL.RawPath = t.route.Rewrite(req.URL.RawPath)
+			}
+		}
+	}
+	if req.TLS != nil {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	}
+}
+
+// requestAddr returns a virtual address that the request is sent to.
+func requestAddr(req *http.Request) (domain.Addr, bool) {
+	hostAndPort := strings.SplitN(req.Host, ":", 2)
+	addr := domain.HTTPAddr(hostAndPort[0])
+	if req.TLS != nil {
+		addr.Port = httpsPort
+	}
+	if len(hostAndPort) == 2 {
+		var err error
+		addr.Port, err = domain.PortFromString(hostAndPort[1])
+		if err != nil {
+			return domain.Addr{}, false
+		}
+	}
+	return addr, addr.Host != ""
 }
 
 func (s *server) localhost() string {
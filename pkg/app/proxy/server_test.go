@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+type fakeMappingRepository struct {
+	domain.MappingRepository
+	mappings map[string]*domain.Mapping
+}
+
+func (r *fakeMappingRepository) Get(ctx context.Context, host string) (*domain.Mapping, bool) {
+	m, ok := r.mappings[host]
+	return m, ok
+}
+
+func (r *fakeMappingRepository) MapAddr(ctx context.Context, addr domain.Addr) (domain.Addr, error) {
+	if m, ok := r.mappings[addr.Host]; ok {
+		if got := m.Map(addr.Port); got.Port != 0 {
+			return got, nil
+		}
+	}
+	return domain.Addr{}, errors.Errorf("%v is not found", addr)
+}
+
+func TestServer_resolve(t *testing.T) {
+	route := func(prefix, target string, strip bool) domain.Route {
+		r, err := domain.NewRoute(prefix, target, strip)
+		if err != nil {
+			t.Fatalf("NewRoute returned an error: %v", err)
+		}
+		return r
+	}
+	s := &server{
+		Config: &Config{TLD: "ery", ExternalRouteHosts: []string{"localhost", "127.0.0.1"}},
+		mappingRepo: &fakeMappingRepository{mappings: map[string]*domain.Mapping{
+			"myapp.ery": {
+				VirtualHost: "myapp.ery",
+				TargetHost:  "10.0.0.1",
+				PortMap:     domain.PortMap{80: 3000, 8080: 3080},
+				Routes: domain.Routes{
+					route("/api", "api.myapp.ery", true),
+					route("/admin", ":8080", false),
+					route("/dev/", "localhost:5000", true),
+					route("/ip", "127.0.0.1:5001", false),
+					route("/external", "example.com:80", false),
+					route("/private", "10.0.0.3:80", false),
+					route("/missing", "missing.myapp.ery", false),
+					route("/unmapped", "api.myapp.ery:9000", false),
+				},
+			},
+			"api.myapp.ery": {
+				VirtualHost: "api.myapp.ery",
+				TargetHost:  "10.0.0.2",
+				PortMap:     domain.PortMap{80: 4000},
+			},
+		}},
+	}
+
+	cases := []struct {
+		path   string
+		host   string
+		rpath  string
+		prefix string
+		code   int
+	}{
+		{path: "/", host: "10.0.0.1:3000", rpath: "/"},
+		{path: "/apis", host: "10.0.0.1:3000", rpath: "/apis"},
+		{path: "/api/users", host: "10.0.0.2:4000", rpath: "/users", prefix: "/api"},
+		{path: "/api", host: "10.0.0.2:4000", rpath: "/", prefix: "/api"},
+		{path: "/admin/users", host: "10.0.0.1:3080", rpath: "/admin/users"},
+		{path: "/dev/app.js", host: "localhost:5000", rpath: "/app.js", prefix: "/dev"},
+		{path: "/ip/users", host: "127.0.0.1:5001", rpath: "/ip/users"},
+		{path: "/external", code: http.StatusBadGateway},
+		{path: "/private", code: http.StatusBadGateway},
+		{path: "/missing", code: http.StatusBadGateway},
+		{path: "/unmapped", code: http.StatusBadGateway},
+	}
+
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://myapp.ery"+c.path, nil)
+
+			tgt, rerr := s.resolve(req)
+			if c.code != 0 {
+				if rerr == nil || rerr.Code != c.code {
+					t.Errorf("resolve should fail with %d, but got %v", c.code, rerr)
+				}
+				return
+			}
+			if rerr != nil {
+				t.Fatalf("resolve returned an error: %v", rerr.Message)
+			}
+
+			req = req.WithContext(context.WithValue(req.Context(), targetKey{}, tgt))
+			s.handle(req)
+			if req.URL.Host != c.host {
+				t.Errorf("request is proxied to %s, want %s", req.URL.Host, c.host)
+			}
+			if req.URL.Path != c.rpath {
+				t.Errorf("path is %s, want %s", req.URL.Path, c.rpath)
+			}
+			if got := req.Header.Get("X-Forwarded-Prefix"); got != c.prefix {
+				t.Errorf("X-Forwarded-Prefix is %q, want %q", got, c.prefix)
+			}
+		})
+	}
+}
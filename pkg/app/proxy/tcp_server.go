@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/srvc/ery/pkg/domain"
+	"github.com/srvc/ery/pkg/util/netutil"
+)
+
+var (
+	// tcpDrainTimeout is a duration to wait for active connections to finish after the server is stopped.
+	tcpDrainTimeout = 30 * time.Second
+	tcpDialTimeout  = 10 * time.Second
+)
+
+// newTCPServer creates a proxy server that splices TCP connections to the target.
+// Each virtual host has its own proxy host, so the target is found by the address that the server listens on.
+func newTCPServer(mappingRepo domain.MappingRepository, addr domain.Addr) Server {
+	return &tcpServer{
+		mappingRepo: mappingRepo,
+		addr:        addr,
+		conns:       map[net.Conn]struct{}{},
+		log:         zap.L().Named("proxy"),
+	}
+}
+
+type tcpServer struct {
+	mappingRepo domain.MappingRepository
+	addr        domain.Addr
+	wg          sync.WaitGroup
+	conns       map[net.Conn]struct{} // active connections of both sides
+	m           sync.Mutex
+	log         *zap.Logger
+}
+
+func (s *tcpServer) Serve(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.addr.String())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.log.Info("starting tcp proxy server...", zap.Stringer("addr", &s.addr))
+		errCh <- errors.WithStack(s.accept(ctx, lis))
+	}()
+
+	select {
+	case err = <-errCh:
+		err = errors.WithStack(err)
+		s.log.Info("shutdowning tcp proxy server...", zap.Error(err), zap.Stringer("addr", &s.addr))
+	case <-ctx.Done():
+		s.log.Info("shutdowning tcp proxy server...", zap.Error(ctx.Err()), zap.Stringer("addr", &s.addr))
+		lis.Close()
+		err = errors.WithStack(<-errCh)
+	}
+
+	s.drain()
+
+	return errors.WithStack(err)
+}
+
+func (s *tcpServer) accept(ctx context.Context, lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.log.Warn("failed to accept a connection, retrying...", zap.Error(err), zap.Stringer("addr", &s.addr))
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return errors.WithStack(err)
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+// drain waits for active connections to finish, and closes ones remaining after the timeout.
+func (s *tcpServer) drain() {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(tcpDrainTimeout):
+		s.m.Lock()
+		s.log.Info("close active connections", zap.Int("count", len(s.conns)), zap.Stringer("addr", &s.addr))
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.m.Unlock()
+		<-done
+	}
+}
+
+func (s *tcpServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	target, err := lookupTarget(ctx, s.mappingRepo, s.addr)
+	if err != nil {
+		s.log.Warn("failed to find a target", zap.Error(err), zap.Stringer("addr", &s.addr))
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", target.String(), tcpDialTimeout)
+	if err != nil {
+		s.log.Warn("failed to connect to the target", zap.Error(err), zap.Stringer("addr", &s.addr), zap.Stringer("target", &target))
+		return
+	}
+	defer upstream.Close()
+
+	s.track(conn, upstream)
+	defer s.untrack(conn, upstream)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		splice(upstream, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		splice(conn, upstream)
+	}()
+	wg.Wait()
+}
+
+// lookupTarget returns an address of the target for the proxy address.
+// It should be called for each connection to balance replicas.
+func lookupTarget(ctx context.Context, mappingRepo domain.MappingRepository, proxyAddr domain.Addr) (domain.Addr, error) {
+	host, ok := mappingRepo.LookupHost(ctx, net.ParseIP(proxyAddr.Host))
+	if !ok {
+		return domain.Addr{}, errors.Errorf("no hosts are registered for %s", proxyAddr.Host)
+	}
+
+	addr, err := mappingRepo.MapAddr(ctx, domain.Addr{Host: host, Port: proxyAddr.Port})
+	if err != nil {
+		return domain.Addr{}, errors.WithStack(err)
+	}
+	if addr.Host == "" {
+		addr.Host = netutil.LocalIP().String()
+	}
+
+	return addr, nil
+}
+
+func (s *tcpServer) track(conns ...net.Conn) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, c := range conns {
+		s.conns[c] = struct{}{}
+	}
+}
+
+func (s *tcpServer) untrack(conns ...net.Conn) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, c := range conns {
+		delete(s.conns, c)
+	}
+}
+
+// splice copies data from src to dst, and then closes the write side of dst to propagate EOF.
+func splice(dst, src net.Conn) {
+	io.Copy(dst, src)
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+var (
+	// udpSessionTimeout is a duration to keep sessions without datagrams in both directions.
+	udpSessionTimeout = 60 * time.Second
+	udpBufferSize     = 64 * 1024
+)
+
+// newUDPServer creates a proxy server that relays datagrams between clients and the target.
+// Each client address has its own session with a socket connected to the target, so replies are sent back to the client.
+func newUDPServer(mappingRepo domain.MappingRepository, addr domain.Addr) Server {
+	return &udpServer{
+		mappingRepo: mappingRepo,
+		addr:        addr,
+		sessions:    map[string]*udpSession{},
+		log:         zap.L().Named("proxy"),
+	}
+}
+
+type udpServer struct {
+	mappingRepo domain.MappingRepository
+	addr        domain.Addr
+	sessions    map[string]*udpSession // by client addresses
+	m           sync.Mutex
+	log         *zap.Logger
+}
+
+type udpSession struct {
+	client     net.Addr
+	upstream   net.Conn
+	lastActive int64 // unix nano
+}
+
+func (s *udpSession) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+func (s *udpSession) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+}
+
+func (s *udpServer) Serve(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.addr.String())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.log.Info("starting udp proxy server...", zap.Stringer("addr", &s.addr))
+		errCh <- errors.WithStack(s.relay(ctx, conn))
+	}()
+
+	select {
+	case err = <-errCh:
+		err = errors.WithStack(err)
+		s.log.Info("shutdowning udp proxy server...", zap.Error(err), zap.Stringer("addr", &s.addr))
+	case <-ctx.Done():
+		s.log.Info("shutdowning udp proxy server...", zap.Error(ctx.Err()), zap.Stringer("addr", &s.addr))
+		conn.Close()
+		err = errors.WithStack(<-errCh)
+	}
+
+	s.m.Lock()
+	for _, sess := range s.sessions {
+		sess.upstream.Close()
+	}
+	s.m.Unlock()
+
+	return errors.WithStack(err)
+}
+
+// relay forwards datagrams from clients to the target until the connection is closed.
+func (s *udpServer) relay(ctx context.Context, conn net.PacketConn) error {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, client, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return errors.WithStack(err)
+		}
+
+		sess, err := s.session(ctx, conn, client)
+		if err != nil {
+			s.log.Warn("failed to start a session", zap.Error(err), zap.Stringer("addr", &s.addr), zap.Stringer("client", client))
+			continue
+		}
+
+		sess.touch()
+		_, err = sess.upstream.Write(buf[:n])
+		if err != nil {
+			s.log.Debug("failed to send a datagram to the target", zap.Error(err), zap.Stringer("addr", &s.addr))
+		}
+	}
+}
+
+// session returns a session for the client, it is created if not exists.
+func (s *udpServer) session(ctx context.Context, conn net.PacketConn, client net.Addr) (*udpSession, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if sess, ok := s.sessions[client.String()]; ok {
+		return sess, nil
+	}
+
+	target, err := lookupTarget(ctx, s.mappingRepo, s.addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	upstream, err := net.Dial("udp", target.String())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sess := &udpSession{client: client, upstream: upstream}
+	sess.touch()
+	s.sessions[client.String()] = sess
+	s.log.Debug("start a session", zap.Stringer("client", client), zap.Stringer("target", &target))
+
+	go s.reply(conn, sess)
+
+	return sess, nil
+}
+
+// reply forwards datagrams from the target to the client, and closes the session when it becomes idle.
+func (s *udpServer) reply(conn net.PacketConn, sess *udpSession) {
+	defer func() {
+		s.m.Lock()
+		if s.sessions[sess.client.String()] == sess {
+			delete(s.sessions, sess.client.String())
+		}
+		s.m.Unlock()
+		sess.upstream.Close()
+		s.log.Debug("close a session", zap.Stringer("client", sess.client))
+	}()
+
+	buf := make([]byte, udpBufferSize)
+	for {
+		sess.upstream.SetReadDeadline(time.Now().Add(udpSessionTimeout - sess.idle()))
+		n, err := sess.upstream.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if sess.idle() < udpSessionTimeout {
+					continue
+				}
+				return
+			}
+			// e.g. the target is not listening, a new session is started by the next datagram
+			return
+		}
+
+		sess.touch()
+		_, err = conn.WriteTo(buf[:n], sess.client)
+		if err != nil {
+			s.log.Debug("failed to send a datagram to the client", zap.Error(err), zap.Stringer("client", sess.client))
+		}
+	}
+}
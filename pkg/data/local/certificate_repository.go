@@ -0,0 +1,253 @@
+package local
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+	// certRenewBefore is a margin to issue a new certificate before the cached one expires.
+	certRenewBefore = 24 * time.Hour
+)
+
+// NewCertificateRepository creates a new CertificateRepository instance that issues certificates with a local CA.
+// The CA is created in the directory at the first use, and reused after that.
+// It is constrained to the TLD, and certificates are issued only for subdomains of the TLD.
+func NewCertificateRepository(fs afero.Fs, dir string, tld string) domain.CertificateRepository {
+	return &certificateRepositoryImpl{
+		fs:        fs,
+		dir:       dir,
+		tld:       tld,
+		certCache: map[string]*tls.Certificate{},
+		log:       zap.L().Named("cert"),
+	}
+}
+
+type certificateRepositoryImpl struct {
+	fs  afero.Fs
+	dir string
+	tld string
+
+	caCert    *x509.Certificate
+	caCertPEM []byte
+	caKey     *ecdsa.PrivateKey
+	certCache map[string]*tls.Certificate
+	m         sync.Mutex
+
+	log *zap.Logger
+}
+
+func (r *certificateRepositoryImpl) GetCACertificate(ctx context.Context) ([]byte, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.loadCA()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return r.caCertPEM, nil
+}
+
+func (r *certificateRepositoryImpl) GetCertificate(ctx context.Context, host string) (*tls.Certificate, error) {
+	if !domain.IsCertifiable(host, r.tld) {
+		return nil, errors.Errorf("certificates are issued only for subdomains of %s, not for %s", r.tld, host)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if cert, ok := r.certCache[host]; ok && time.Until(cert.Leaf.NotAfter) > certRenewBefore {
+		return cert, nil
+	}
+
+	err := r.loadCA()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	cert, err := r.issue(host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to issue a certificate for %s", host)
+	}
+	r.certCache[host] = cert
+
+	r.log.Info("issued a certificate", zap.String("host", host), zap.Time("not_after", cert.Leaf.NotAfter))
+
+	return cert, nil
+}
+
+// loadCA reads the CA from the directory, or creates it if not exists. It should be called with the lock.
+func (r *certificateRepositoryImpl) loadCA() error {
+	if r.caCert != nil {
+		return nil
+	}
+
+	certPath, keyPath := filepath.Join(r.dir, caCertFile), filepath.Join(r.dir, caKeyFile)
+
+	certPEM, err := afero.ReadFile(r.fs, certPath)
+	if os.IsNotExist(errors.Cause(err)) {
+		return errors.WithStack(r.createCA(certPath, keyPath))
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	keyPEM, err := afero.ReadFile(r.fs, keyPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse the CA in %s", r.dir)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return errors.Errorf("the CA key in %s should be an ECDSA key", r.dir)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !r.isConstrained(cert) {
+		// CAs created by older versions can sign certificates of any domains
+		r.log.Warn("the CA is not constrained to the TLD, create a new one. it should be trusted again with `ery cert`", zap.String("path", certPath), zap.String("tld", r.tld))
+		return errors.WithStack(r.createCA(certPath, keyPath))
+	}
+
+	r.caCert, r.caCertPEM, r.caKey = cert, certPEM, key
+
+	return nil
+}
+
+func (r *certificateRepositoryImpl) createCA(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"ery"}, CommonName: "ery local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		// a leaked CA key cannot sign certificates trusted for other domains
+		PermittedDNSDomains:         []string{r.tld},
+		PermittedDNSDomainsCritical: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	err = r.fs.MkdirAll(r.dir, 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = afero.WriteFile(r.fs, keyPath, keyPEM, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = afero.WriteFile(r.fs, certPath, certPEM, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	r.log.Info("created a new CA", zap.String("path", certPath))
+	r.caCert, r.caCertPEM, r.caKey = cert, certPEM, key
+
+	return nil
+}
+
+// isConstrained returns true if the CA can sign certificates only for the TLD.
+func (r *certificateRepositoryImpl) isConstrained(ca *x509.Certificate) bool {
+	return ca.PermittedDNSDomainsCritical && len(ca.PermittedDNSDomains) == 1 && ca.PermittedDNSDomains[0] == r.tld
+}
+
+// issue creates a certificate for the host signed by the CA. It should be called with the lock.
+func (r *certificateRepositoryImpl) issue(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"ery"}, CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, r.caCert, key.Public(), r.caKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, r.caCert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return n, errors.WithStack(err)
+}
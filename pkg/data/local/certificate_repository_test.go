@@ -0,0 +1,75 @@
+package local
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestCertificateRepository_GetCertificate(t *testing.T) {
+	ctx := context.Background()
+	repo := NewCertificateRepository(afero.NewMemMapFs(), "/ca", "ery")
+
+	caPEM, err := repo.GetCACertificate(ctx)
+	if err != nil {
+		t.Fatalf("GetCACertificate returned an error: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatal("CA certificate should be PEM encoded")
+	}
+
+	cases := []struct {
+		host   string
+		issued bool
+	}{
+		{host: "myapp.ery", issued: true},
+		{host: "api.myapp.ery", issued: true},
+		{host: "ery"},
+		{host: "github.com"},
+		{host: "myapp.ery.example.com"},
+		{host: "evilery"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.host, func(t *testing.T) {
+			cert, err := repo.GetCertificate(ctx, c.host)
+			if !c.issued {
+				if err == nil {
+					t.Errorf("certificate for %s should not be issued", c.host)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetCertificate returned an error: %v", err)
+			}
+			_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: c.host, Roots: roots})
+			if err != nil {
+				t.Errorf("certificate should be verified with the CA: %v", err)
+			}
+		})
+	}
+}
+
+func TestCertificateRepository_CAConstraints(t *testing.T) {
+	repo := NewCertificateRepository(afero.NewMemMapFs(), "/ca", "ery").(*certificateRepositoryImpl)
+
+	_, err := repo.GetCACertificate(context.Background())
+	if err != nil {
+		t.Fatalf("GetCACertificate returned an error: %v", err)
+	}
+
+	// certificates of other domains signed with the CA key are not trusted
+	cert, err := repo.issue("github.com")
+	if err != nil {
+		t.Fatalf("issue returned an error: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(repo.caCert)
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "github.com", Roots: roots})
+	if _, ok := err.(x509.CertificateInvalidError); !ok {
+		t.Errorf("certificate for github.com should be rejected by name constraints, but got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package local
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+var (
+	minReconnectInterval = 500 * time.Millisecond
+	maxReconnectInterval = 30 * time.Second
+)
+
+// containerEventStream connects to a container runtime and emits events until the connection is lost.
+// connected reports whether the connection has been established.
+type containerEventStream func(ctx context.Context, evCh chan<- domain.ContainerEvent) (connected bool, err error)
+
+// listenContainerEvents runs the stream repeatedly until the context is canceled.
+// When the connection is lost, it reconnects with exponential backoff and reports the endpoint unhealthy.
+// A resync event is emitted before each reconnection, so that listeners can reconcile with containers listed again.
+func listenContainerEvents(
+	ctx context.Context,
+	endpoint domain.ContainerEndpoint,
+	statusRepo domain.StatusRepository,
+	log *zap.Logger,
+	stream containerEventStream,
+) (<-chan domain.ContainerEvent, <-chan error) {
+	evCh := make(chan domain.ContainerEvent)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(evCh)
+		defer close(errCh)
+
+		interval := minReconnectInterval
+
+		for {
+			connected, err := stream(ctx, evCh)
+			if ctx.Err() != nil {
+				return
+			}
+			if connected {
+				interval = minReconnectInterval
+			}
+
+			log.Warn("lost connection to the container runtime, reconnecting...", zap.Stringer("endpoint", endpoint), zap.Duration("interval", interval), zap.Error(err))
+			setContainerStatus(ctx, statusRepo, log, endpoint, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+
+			interval *= 2
+			if interval > maxReconnectInterval {
+				interval = maxReconnectInterval
+			}
+
+			err = emitContainerEvent(ctx, evCh, &domain.ContainerEvent{Type: domain.ContainerEventResync})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return evCh, errCh
+}
+
+func emitContainerEvent(ctx context.Context, evCh chan<- domain.ContainerEvent, ev *domain.ContainerEvent) error {
+	select {
+	case evCh <- *ev:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// setContainerStatus reports the endpoint healthy if err is nil.
+func setContainerStatus(ctx context.Context, statusRepo domain.StatusRepository, log *zap.Logger, endpoint domain.ContainerEndpoint, err error) {
+	status := &domain.Status{Component: endpoint.String(), Healthy: err == nil}
+	if err != nil {
+		status.Message = err.Error()
+	}
+	if err := statusRepo.Set(ctx, status); err != nil {
+		log.Warn("failed to update status", zap.Error(err))
+	}
+}
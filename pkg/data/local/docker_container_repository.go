@@ -2,74 +2,194 @@ package local
 
 import (
 	"context"
+	"net"
+	"sort"
 	"strings"
 
+	"github.com/docker/docker/api"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/go-connections/nat"
 	"github.com/moby/moby/client"
 	"github.com/pkg/errors"
 	"github.com/srvc/ery/pkg/domain"
 	"go.uber.org/zap"
 )
 
-// NewDockerContainerRepository creates a new ContainerRepository instance concerned to local docker containers.
-func NewDockerContainerRepository() domain.ContainerRepository {
+// NewDockerContainerRepository creates a new ContainerRepository instance concerned to containers of the endpoint.
+// The endpoint should serve the Docker API, such as docker and podman.
+// A connection status to the endpoint is reported to the status repository.
+func NewDockerContainerRepository(endpoint domain.ContainerEndpoint, statusRepo domain.StatusRepository) domain.ContainerRepository {
 	return &dockerContainerRepository{
-		log: zap.L().Named("docker"),
+		endpoint:   endpoint,
+		statusRepo: statusRepo,
+		log:        zap.L().Named(endpoint.Platform.String()),
 	}
 }
 
 type dockerContainerRepository struct {
-	log *zap.Logger
+	endpoint   domain.ContainerEndpoint
+	statusRepo domain.StatusRepository
+	log        *zap.Logger
 }
 
-func (r *dockerContainerRepository) ListenEvent(ctx context.Context) (_evCh <-chan domain.ContainerEvent, _errCh <-chan error) {
-	evCh := make(chan domain.ContainerEvent)
-	errCh := make(chan error, 1)
-	_evCh, _errCh = evCh, errCh
+// newClient creates an API client for the endpoint. Environment variables (e.g. DOCKER_HOST) are used if the endpoint does not have a host.
+func (r *dockerContainerRepository) newClient() (*client.Client, error) {
+	if r.endpoint.Host == "" {
+		cli, err := client.NewEnvClient()
+		return cli, errors.WithStack(err)
+	}
+	cli, err := client.NewClient(r.endpoint.Host, api.DefaultVersion, nil, nil)
+	return cli, errors.WithStack(err)
+}
+
+func (r *dockerContainerRepository) List(ctx context.Context) ([]domain.Container, error) {
+	cli, err := r.newClient()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer cli.Close()
 
-	go func() {
-		defer close(evCh)
-		defer close(errCh)
+	return r.listRunningContainers(ctx, cli)
+}
 
-		client, err := client.NewEnvClient()
+func (r *dockerContainerRepository) listRunningContainers(ctx context.Context, cli client.APIClient) ([]domain.Container, error) {
+	summaries, err := cli.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	containers := make([]domain.Container, 0, len(summaries))
+	for _, s := range summaries {
+		c, err := r.inspect(ctx, cli, s.ID)
 		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
+			r.log.Warn("failed to inspect container", zap.String("id", s.ID), zap.Error(err))
+			continue
 		}
-		defer client.Close()
+		containers = append(containers, *c)
+	}
+
+	return containers, nil
+}
+
+// ListenEvent emits events of containers, including ones running before listening.
+// When the connection to the endpoint is lost, it reconnects with exponential backoff,
+// and then resyncs running containers, emitting created and destroyed events for differences.
+func (r *dockerContainerRepository) ListenEvent(ctx context.Context) (<-chan domain.ContainerEvent, <-chan error) {
+	known := map[string]struct{}{}
+	return listenContainerEvents(ctx, r.endpoint, r.statusRepo, r.log, func(ctx context.Context, evCh chan<- domain.ContainerEvent) (bool, error) {
+		return r.streamEvents(ctx, evCh, known)
+	})
+}
+
+// streamEvents emits events until the connection is lost.
+// known is a set of IDs of running containers, it is updated with emitted events.
+func (r *dockerContainerRepository) streamEvents(ctx context.Context, evCh chan<- domain.ContainerEvent, known map[string]struct{}) (connected bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cli, err := r.newClient()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer cli.Close()
+
+	dockerEvCh, dockerErrCh := r.listenDockerEvent(ctx, cli)
 
-		dockerEvCh, dockerErrCh := r.listenDockerEvent(ctx, client)
+	// list containers after subscribing events not to miss ones started in the meantime
+	err = r.resync(ctx, cli, evCh, known)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	r.log.Info("connected to the container runtime", zap.Stringer("endpoint", r.endpoint))
+	setContainerStatus(ctx, r.statusRepo, r.log, r.endpoint, nil)
 
-		for {
-			select {
-			case ev := <-dockerEvCh:
-				r.log.Debug("receive event", zap.Any("message", ev))
+	for {
+		select {
+		case ev := <-dockerEvCh:
+			r.log.Debug("receive event", zap.Any("message", ev))
 
-				switch ev.Action {
-				case "start":
-					evCh <- *r.handleStart(ctx, client, ev)
-				case "die":
-					evCh <- *r.handleDie(ctx, client, ev)
+			switch {
+			case ev.Type == events.NetworkEventType:
+				// "connect" or "disconnect", containers that have not started or have died are ignored
+				if id := ev.Actor.Attributes["container"]; isKnown(known, id) {
+					err = emitContainerEvent(ctx, evCh, r.handleUpdate(ctx, cli, id))
 				}
-			case err := <-dockerErrCh:
-				if err != context.Canceled {
-					r.log.Warn("receive error", zap.Error(err))
-					errCh <- errors.WithStack(err)
+			case ev.Action == "rename", ev.Action == "pause", ev.Action == "unpause":
+				if isKnown(known, ev.ID) {
+					err = emitContainerEvent(ctx, evCh, r.handleUpdate(ctx, cli, ev.ID))
 				}
-				return
+			case ev.Action == "start":
+				known[ev.ID] = struct{}{}
+				err = emitContainerEvent(ctx, evCh, r.handleStart(ctx, cli, ev))
+			case ev.Action == "die":
+				delete(known, ev.ID)
+				err = emitContainerEvent(ctx, evCh, r.handleDie(ctx, cli, ev))
+			case strings.HasPrefix(ev.Action, "health_status"):
+				// e.g. "health_status: healthy"
+				err = emitContainerEvent(ctx, evCh, r.handleHealthStatus(ctx, cli, ev))
+			}
+			if err != nil {
+				return true, errors.WithStack(err)
 			}
+		case err := <-dockerErrCh:
+			return true, errors.WithStack(err)
 		}
-	}()
+	}
+}
 
-	return
+// resync emits created events for running containers and destroyed events for known containers that have stopped.
+// Known containers that are still running are notified as updated.
+func (r *dockerContainerRepository) resync(ctx context.Context, cli client.APIClient, evCh chan<- domain.ContainerEvent, known map[string]struct{}) error {
+	containers, err := r.listRunningContainers(ctx, cli)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	r.log.Debug("found running containers", zap.Int("count", len(containers)))
+
+	running := make(map[string]struct{}, len(containers))
+	for _, c := range containers {
+		running[c.ID] = struct{}{}
+	}
+
+	for id := range known {
+		if _, ok := running[id]; ok {
+			continue
+		}
+		delete(known, id)
+		err = emitContainerEvent(ctx, evCh, &domain.ContainerEvent{
+			Type:      domain.ContainerEventDestroyed,
+			Container: domain.Container{ID: id, Platform: r.endpoint.Platform},
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, c := range containers {
+		// containers known before reconnecting may have been changed, e.g. renamed
+		typ := domain.ContainerEventCreated
+		if isKnown(known, c.ID) {
+			typ = domain.ContainerEventUpdated
+		}
+		known[c.ID] = struct{}{}
+		err = emitContainerEvent(ctx, evCh, &domain.ContainerEvent{Type: typ, Container: c})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
 }
 
 func (r *dockerContainerRepository) listenDockerEvent(ctx context.Context, cli client.APIClient) (<-chan events.Message, <-chan error) {
 	args := filters.NewArgs()
-	args.Add("type", "container")
-	for _, a := range []string{"start", "die"} {
+	args.Add("type", events.ContainerEventType)
+	args.Add("type", events.NetworkEventType)
+	for _, a := range []string{"start", "die", "health_status", "rename", "pause", "unpause", "connect", "disconnect"} {
 		args.Add("event", a)
 	}
 	return cli.Events(ctx, types.EventsOptions{Filters: args})
@@ -80,39 +200,123 @@ func (r *dockerContainerRepository) handleStart(ctx context.Context, cli client.
 		Type: domain.ContainerEventCreated,
 		Container: domain.Container{
 			ID:           msg.ID,
-			Platform:     domain.ContainerPlatformDocker,
+			Platform:     r.endpoint.Platform,
 			PortBindings: map[domain.Port][]domain.Port{},
 		},
 	}
 
-	data, err := cli.ContainerInspect(ctx, msg.ID)
+	c, err := r.inspect(ctx, cli, msg.ID)
 	if err != nil {
 		r.log.Warn("failed to inspect container", zap.String("id", msg.ID), zap.Error(err))
 		ev.Error = errors.Wrap(err, "failed to inspect container")
 		return
 	}
+	ev.Container = *c
+
+	return ev
+}
+
+func (r *dockerContainerRepository) handleHealthStatus(ctx context.Context, cli client.APIClient, msg events.Message) (ev *domain.ContainerEvent) {
+	ev = r.handleStart(ctx, cli, msg)
+	ev.Type = domain.ContainerEventHealthChanged
+	return ev
+}
+
+func (r *dockerContainerRepository) handleUpdate(ctx context.Context, cli client.APIClient, id string) (ev *domain.ContainerEvent) {
+	ev = r.handleStart(ctx, cli, events.Message{ID: id})
+	ev.Type = domain.ContainerEventUpdated
+	return ev
+}
+
+var containerHealthByStatus = map[string]domain.ContainerHealth{
+	types.Starting:  domain.ContainerHealthStarting,
+	types.Healthy:   domain.ContainerHealthHealthy,
+	types.Unhealthy: domain.ContainerHealthUnhealthy,
+}
+
+func (r *dockerContainerRepository) inspect(ctx context.Context, cli client.APIClient, id string) (*domain.Container, error) {
+	data, err := cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := &domain.Container{
+		ID:           id,
+		Name:         strings.TrimPrefix(data.Name, "/"),
+		Platform:     r.endpoint.Platform,
+		Labels:       data.Config.Labels,
+		PortBindings: map[domain.Port][]domain.Port{},
+		Protocols:    map[domain.Port]domain.Protocol{},
+	}
+
+	if data.State != nil {
+		c.Paused = data.State.Paused
+		if data.State.Health != nil {
+			c.Health = containerHealthByStatus[data.State.Health.Status]
+		}
+	}
+
+	for name, settings := range data.NetworkSettings.Networks {
+		n := domain.ContainerNetwork{Name: name}
+		if settings != nil {
+			n.IP = net.ParseIP(settings.IPAddress)
+		}
+		c.Networks = append(c.Networks, n)
+	}
+	sort.Slice(c.Networks, func(i, j int) bool { return c.Networks[i].Name < c.Networks[j].Name })
+
+	exposed := make([]domain.TransportPort, 0, len(data.Config.ExposedPorts))
+	for p := range data.Config.ExposedPorts {
+		exposed = append(exposed, transportPort(p))
+	}
+	for p, protocol := range domain.RelayedPorts(exposed) {
+		c.ExposedPorts = append(c.ExposedPorts, p.Port)
+		if protocol != domain.ProtocolAuto {
+			c.Protocols[p.Port] = protocol
+		}
+	}
+	sort.Slice(c.ExposedPorts, func(i, j int) bool { return c.ExposedPorts[i] < c.ExposedPorts[j] })
 
-	ev.Container.Name = strings.TrimPrefix(data.Name, "/")
-	ev.Container.Labels = data.Config.Labels
+	published := make([]domain.TransportPort, 0, len(data.NetworkSettings.Ports))
+	for k := range data.NetworkSettings.Ports {
+		published = append(published, transportPort(k))
+	}
+	relayed := domain.RelayedPorts(published)
 
 	for k, v := range data.NetworkSettings.Ports {
 		if v == nil {
 			continue
 		}
 
+		protocol, ok := relayed[transportPort(k)]
+		if !ok {
+			continue
+		}
 		cport := domain.Port(k.Int())
+		if protocol != domain.ProtocolAuto {
+			c.Protocols[cport] = protocol
+		}
 
 		for _, b := range v {
 			hport, err := domain.PortFromString(b.HostPort)
 			if err != nil {
-				r.log.Warn("failed to find the port number", zap.String("id", msg.ID), zap.Any("binding", b), zap.Error(err))
+				r.log.Warn("failed to find the port number", zap.String("id", id), zap.Any("binding", b), zap.Error(err))
 				continue
 			}
-			ev.Container.PortBindings[cport] = append(ev.Container.PortBindings[cport], hport)
+			c.PortBindings[cport] = append(c.PortBindings[cport], hport)
 		}
 	}
 
-	return ev
+	return c, nil
+}
+
+func transportPort(p nat.Port) domain.TransportPort {
+	return domain.TransportPort{Port: domain.Port(p.Int()), Transport: p.Proto()}
+}
+
+func isKnown(known map[string]struct{}, id string) bool {
+	_, ok := known[id]
+	return ok
 }
 
 func (r *dockerContainerRepository) handleDie(ctx context.Context, cli client.APIClient, msg events.Message) (ev *domain.ContainerEvent) {
@@ -120,7 +324,7 @@ func (r *dockerContainerRepository) handleDie(ctx context.Context, cli client.AP
 		Type: domain.ContainerEventDestroyed,
 		Container: domain.Container{
 			ID:       msg.ID,
-			Platform: domain.ContainerPlatformDocker,
+			Platform: r.endpoint.Platform,
 		},
 	}
 	return
@@ -0,0 +1,145 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// newFakeDockerServer starts a server speaking a subset of the Docker API on the unix socket.
+// It has a running container "abc", and notifies that the container has died on listening events.
+func newFakeDockerServer(t *testing.T, sock string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"Id":"abc","Names":["/web"]}]`)
+	})
+	mux.HandleFunc("/containers/abc/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"Id": "abc",
+			"Name": "/web",
+			"Config": {
+				"Labels": {"tools.srvc.ery.hostname": "web.ery"},
+				"ExposedPorts": {"80/tcp": {}}
+			},
+			"NetworkSettings": {
+				"Ports": {"80/tcp": [{"HostIp": "0.0.0.0", "HostPort": "32768"}]},
+				"Networks": {"podman": {"IPAddress": "10.88.0.2"}}
+			}
+		}`)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"Type":"container","Action":"die","id":"abc","Actor":{"ID":"abc"}}`+"\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("failed to listen %s: %v", sock, err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+		mux.ServeHTTP(w, r)
+	}))
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+
+	return srv
+}
+
+func TestDockerContainerRepository_ListenEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ery")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "podman.sock")
+	srv := newFakeDockerServer(t, sock)
+	defer srv.Close()
+
+	endpoint := domain.ContainerEndpoint{Platform: domain.ContainerPlatformPodman, Host: "unix://" + sock}
+	statusRepo := NewStatusRepository()
+	repo := NewDockerContainerRepository(endpoint, statusRepo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	evCh, errCh := repo.ListenEvent(ctx)
+
+	receive := func() domain.ContainerEvent {
+		t.Helper()
+		select {
+		case ev := <-evCh:
+			return ev
+		case err := <-errCh:
+			t.Fatalf("ListenEvent returned an error: %v", err)
+		case <-ctx.Done():
+			t.Fatalf("timed out: %v", ctx.Err())
+		}
+		return domain.ContainerEvent{}
+	}
+
+	ev := receive()
+	if got, want := ev.Type, domain.ContainerEventCreated; got != want {
+		t.Errorf("Type is %v, want %v", got, want)
+	}
+	c := ev.Container
+	if got, want := c.ID, "abc"; got != want {
+		t.Errorf("ID is %q, want %q", got, want)
+	}
+	if got, want := c.Name, "web"; got != want {
+		t.Errorf("Name is %q, want %q", got, want)
+	}
+	if got, want := c.Platform, domain.ContainerPlatformPodman; got != want {
+		t.Errorf("Platform is %v, want %v", got, want)
+	}
+	if got, want := c.Labels["tools.srvc.ery.hostname"], "web.ery"; got != want {
+		t.Errorf("hostname label is %q, want %q", got, want)
+	}
+	if got := c.PortBindings[80]; len(got) != 1 || got[0] != 32768 {
+		t.Errorf("PortBindings[80] is %v, want [32768]", got)
+	}
+	if got := c.ExposedPorts; len(got) != 1 || got[0] != 80 {
+		t.Errorf("ExposedPorts is %v, want [80]", got)
+	}
+	if ip, ok := c.IP(); !ok || ip.String() != "10.88.0.2" {
+		t.Errorf("IP() returned %v, want 10.88.0.2", ip)
+	}
+
+	ev = receive()
+	if got, want := ev.Type, domain.ContainerEventDestroyed; got != want {
+		t.Errorf("Type is %v, want %v", got, want)
+	}
+	if got, want := ev.Container.ID, "abc"; got != want {
+		t.Errorf("ID is %q, want %q", got, want)
+	}
+	if got, want := ev.Container.Platform, domain.ContainerPlatformPodman; got != want {
+		t.Errorf("Platform is %v, want %v", got, want)
+	}
+
+	statuses, err := statusRepo.List(ctx)
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Component != endpoint.String() || !statuses[0].Healthy {
+		t.Errorf("statuses are %+v, want a healthy status of %s", statuses, endpoint)
+	}
+}
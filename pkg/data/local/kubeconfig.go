@@ -0,0 +1,228 @@
+package local
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// kubeconfig is a subset of kubeconfig files that is required to access the API server.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string      `yaml:"name"`
+		Cluster kubeCluster `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string   `yaml:"name"`
+		User kubeUser `yaml:"user"`
+	} `yaml:"users"`
+}
+
+type kubeCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+}
+
+type kubeUser struct {
+	ClientCertificate     string `yaml:"client-certificate"`
+	ClientCertificateData string `yaml:"client-certificate-data"`
+	ClientKey             string `yaml:"client-key"`
+	ClientKeyData         string `yaml:"client-key-data"`
+	Token                 string `yaml:"token"`
+	Username              string `yaml:"username"`
+	Password              string `yaml:"password"`
+}
+
+// kubeClient is a minimal client of the Kubernetes API server.
+type kubeClient struct {
+	server string
+	user   kubeUser
+	tls    *tls.Config
+	client *http.Client
+}
+
+// kubeconfigPath returns the path given explicitly, the first path in $KUBECONFIG, or ~/.kube/config.
+func kubeconfigPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	if paths := filepath.SplitList(os.Getenv("KUBECONFIG")); len(paths) > 0 {
+		return paths[0], nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return filepath.Join(home, ".kube", "config"), nil
+}
+
+// readKubeconfig returns the resolved path and contents of the kubeconfig.
+func readKubeconfig(path string) (string, []byte, error) {
+	path, err := kubeconfigPath(path)
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	return path, data, nil
+}
+
+// newKubeClient creates a client for the current context of the kubeconfig read from the path.
+func newKubeClient(path string, data []byte) (*kubeClient, error) {
+	var cfg kubeconfig
+	err := yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+
+	var clusterName, userName string
+	for _, c := range cfg.Contexts {
+		if c.Name == cfg.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, errors.Errorf("context %q is not found in %s", cfg.CurrentContext, path)
+	}
+
+	c := &kubeClient{}
+	var cluster *kubeCluster
+	for i := range cfg.Clusters {
+		if cfg.Clusters[i].Name == clusterName {
+			cluster = &cfg.Clusters[i].Cluster
+		}
+	}
+	if cluster == nil {
+		return nil, errors.Errorf("cluster %q is not found in %s", clusterName, path)
+	}
+	for _, u := range cfg.Users {
+		if u.Name == userName {
+			c.user = u.User
+		}
+	}
+	c.server = strings.TrimSuffix(cluster.Server, "/")
+
+	c.tls, err = kubeTLSConfig(filepath.Dir(path), cluster, &c.user)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c.client = &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: c.tls,
+	}}
+
+	return c, nil
+}
+
+func kubeTLSConfig(dir string, cluster *kubeCluster, user *kubeUser) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: cluster.InsecureSkipTLSVerify}
+
+	ca, err := readKubeData(dir, cluster.CertificateAuthorityData, cluster.CertificateAuthority)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read a certificate authority")
+	}
+	if ca != nil {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("failed to parse a certificate authority")
+		}
+	}
+
+	cert, err := readKubeData(dir, user.ClientCertificateData, user.ClientCertificate)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read a client certificate")
+	}
+	key, err := readKubeData(dir, user.ClientKeyData, user.ClientKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read a client key")
+	}
+	if cert != nil && key != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+
+	return cfg, nil
+}
+
+// readKubeData decodes base64 encoded data, or reads the file relative to the kubeconfig.
+func readKubeData(dir, data, path string) ([]byte, error) {
+	if data != "" {
+		out, err := base64.StdEncoding.DecodeString(data)
+		return out, errors.WithStack(err)
+	}
+	if path == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	out, err := ioutil.ReadFile(path)
+	return out, errors.WithStack(err)
+}
+
+// kubeStatusError is returned when the API server responds with an error status.
+type kubeStatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *kubeStatusError) Error() string {
+	return "kubernetes API returned " + e.Status
+}
+
+// get sends a GET request to the API server. The response body should be closed by callers.
+func (c *kubeClient) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.server+path, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	c.authorize(req.Header)
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.WithStack(&kubeStatusError{Code: resp.StatusCode, Status: resp.Status})
+	}
+
+	return resp, nil
+}
+
+// authorize sets credentials of the user into the header. Client certificates are sent with the TLS config instead.
+func (c *kubeClient) authorize(h http.Header) {
+	switch {
+	case c.user.Token != "":
+		h.Set("Authorization", "Bearer "+c.user.Token)
+	case c.user.Username != "":
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.user.Username+":"+c.user.Password)))
+	}
+}
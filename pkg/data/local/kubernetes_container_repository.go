@@ -0,0 +1,606 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+// NewKubernetesContainerRepository creates a new ContainerRepository instance concerned to services of a Kubernetes cluster.
+// The host of the endpoint is a path of kubeconfig, $KUBECONFIG or ~/.kube/config is used if it is empty.
+// Services are regarded as containers, their namespaces are regarded as networks.
+// Services having node ports are reached through them, and TCP ports of other services are forwarded from the local host to their ready pods.
+func NewKubernetesContainerRepository(endpoint domain.ContainerEndpoint, statusRepo domain.StatusRepository) domain.ContainerRepository {
+	return &kubernetesContainerRepository{
+		endpoint:   endpoint,
+		statusRepo: statusRepo,
+		log:        zap.L().Named(endpoint.Platform.String()),
+		forwards:   map[string]*kubeServiceForward{},
+	}
+}
+
+type kubernetesContainerRepository struct {
+	endpoint   domain.ContainerEndpoint
+	statusRepo domain.StatusRepository
+	log        *zap.Logger
+
+	cli     *kubeClient
+	cliData []byte // kubeconfig that cli is created from
+	m       sync.Mutex
+
+	forwards map[string]*kubeServiceForward // service uid -> forwarded ports
+	fm       sync.Mutex
+}
+
+// client returns a client for the kubeconfig.
+// It is reused while the kubeconfig is unchanged, so connections to the API server are kept alive.
+func (r *kubernetesContainerRepository) client() (*kubeClient, error) {
+	path, data, err := readKubeconfig(r.endpoint.Host)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.cli != nil && bytes.Equal(data, r.cliData) {
+		return r.cli, nil
+	}
+
+	cli, err := newKubeClient(path, data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if r.cli != nil {
+		r.cli.client.CloseIdleConnections()
+	}
+	r.cli, r.cliData = cli, data
+
+	return cli, nil
+}
+
+type kubeObjectMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace"`
+	UID               string            `json:"uid"`
+	ResourceVersion   string            `json:"resourceVersion"`
+	Labels            map[string]string `json:"labels"`
+	DeletionTimestamp *string           `json:"deletionTimestamp"`
+}
+
+type kubeListMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// kubeIntOrString is a port number or a name of a container port.
+type kubeIntOrString struct {
+	IntVal int
+	StrVal string
+}
+
+func (v *kubeIntOrString) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return errors.WithStack(json.Unmarshal(data, &v.StrVal))
+	}
+	return errors.WithStack(json.Unmarshal(data, &v.IntVal))
+}
+
+type kubeService struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		Selector map[string]string `json:"selector"`
+		Ports    []struct {
+			Name       string          `json:"name"`
+			Protocol   string          `json:"protocol"`
+			Port       int             `json:"port"`
+			TargetPort kubeIntOrString `json:"targetPort"`
+			NodePort   int             `json:"nodePort"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+type kubeServiceList struct {
+	Metadata kubeListMeta  `json:"metadata"`
+	Items    []kubeService `json:"items"`
+}
+
+type kubePod struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		Containers []struct {
+			Ports []struct {
+				Name          string `json:"name"`
+				ContainerPort int    `json:"containerPort"`
+			} `json:"ports"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		Phase      string `json:"phase"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+type kubePodList struct {
+	Metadata kubeListMeta `json:"metadata"`
+	Items    []kubePod    `json:"items"`
+}
+
+type kubeWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+
+	resource string // e.g. "services"
+}
+
+// nodePorts returns node ports of the service by its ports.
+func (s *kubeService) nodePorts() map[int]int {
+	ports := map[int]int{}
+	for _, p := range s.Spec.Ports {
+		if p.NodePort != 0 {
+			ports[p.Port] = p.NodePort
+		}
+	}
+	return ports
+}
+
+// container converts the service into a container whose ports are bound to the host ports on the host IP.
+// Ports missing in hostPorts are skipped. It returns false if no ports are bound.
+func (s *kubeService) container(platform domain.ContainerPlatform, hostIP net.IP, hostPorts map[int]int) (domain.Container, bool) {
+	c := domain.Container{
+		ID:           s.Metadata.UID,
+		Name:         s.Metadata.Name,
+		Platform:     platform,
+		Labels:       s.Metadata.Labels,
+		Networks:     []domain.ContainerNetwork{{Name: s.Metadata.Namespace}},
+		PortBindings: map[domain.Port][]domain.Port{},
+		HostIP:       hostIP,
+		Protocols:    map[domain.Port]domain.Protocol{},
+	}
+	published := make([]domain.TransportPort, 0, len(s.Spec.Ports))
+	for _, p := range s.Spec.Ports {
+		if _, ok := hostPorts[p.Port]; ok {
+			published = append(published, domain.TransportPort{Port: domain.Port(p.Port), Transport: p.Protocol})
+		}
+	}
+	relayed := domain.RelayedPorts(published)
+
+	for _, p := range s.Spec.Ports {
+		hostPort, ok := hostPorts[p.Port]
+		if !ok {
+			continue
+		}
+		protocol, ok := relayed[domain.TransportPort{Port: domain.Port(p.Port), Transport: p.Protocol}]
+		if !ok {
+			continue
+		}
+		if protocol != domain.ProtocolAuto {
+			c.Protocols[domain.Port(p.Port)] = protocol
+		}
+		c.PortBindings[domain.Port(p.Port)] = append(c.PortBindings[domain.Port(p.Port)], domain.Port(hostPort))
+	}
+	return c, len(c.PortBindings) > 0
+}
+
+// selects returns true if the selector of the service matches the pod.
+func (s *kubeService) selects(pod *kubePod) bool {
+	if len(s.Spec.Selector) == 0 || s.Metadata.Namespace != pod.Metadata.Namespace {
+		return false
+	}
+	for k, v := range s.Spec.Selector {
+		if pod.Metadata.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ready returns true if the pod is running and ready to accept connections.
+func (p *kubePod) ready() bool {
+	if p.Metadata.DeletionTimestamp != nil || p.Status.Phase != "Running" {
+		return false
+	}
+	for _, c := range p.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+// port resolves the target port of a service port into a container port. It returns false if the named port is not found.
+func (p *kubePod) port(target kubeIntOrString, port int) (int, bool) {
+	switch {
+	case target.StrVal != "":
+		for _, c := range p.Spec.Containers {
+			for _, cp := range c.Ports {
+				if cp.Name == target.StrVal {
+					return cp.ContainerPort, true
+				}
+			}
+		}
+		return 0, false
+	case target.IntVal != 0:
+		return target.IntVal, true
+	default:
+		return port, true
+	}
+}
+
+// kubeObjects is a set of services and pods in the cluster.
+type kubeObjects struct {
+	services map[string]kubeService // uid -> service
+	pods     map[string]kubePod     // uid -> pod
+
+	servicesVersion string
+	podsVersion     string
+}
+
+// pod returns a ready pod selected by the service. Pods are chosen in the order of names to make forwarding stable.
+func (o *kubeObjects) pod(svc *kubeService) *kubePod {
+	var found *kubePod
+	for uid := range o.pods {
+		pod := o.pods[uid]
+		if !svc.selects(&pod) || !pod.ready() {
+			continue
+		}
+		if found == nil || pod.Metadata.Name < found.Metadata.Name {
+			found = &pod
+		}
+	}
+	return found
+}
+
+// update applies the watch event to the objects.
+func (o *kubeObjects) update(ev *kubeWatchEvent) error {
+	switch ev.resource {
+	case "services":
+		var svc kubeService
+		err := json.Unmarshal(ev.Object, &svc)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if ev.Type == "DELETED" {
+			delete(o.services, svc.Metadata.UID)
+		} else {
+			o.services[svc.Metadata.UID] = svc
+		}
+	case "pods":
+		var pod kubePod
+		err := json.Unmarshal(ev.Object, &pod)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if ev.Type == "DELETED" {
+			delete(o.pods, pod.Metadata.UID)
+		} else {
+			o.pods[pod.Metadata.UID] = pod
+		}
+	}
+	return nil
+}
+
+func (r *kubernetesContainerRepository) List(ctx context.Context) ([]domain.Container, error) {
+	cli, err := r.client()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	objs, err := r.listObjects(ctx, cli)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var containers []domain.Container
+	for _, c := range r.containers(objs) {
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].ID < containers[j].ID })
+
+	return containers, nil
+}
+
+func (r *kubernetesContainerRepository) listObjects(ctx context.Context, cli *kubeClient) (*kubeObjects, error) {
+	var services kubeServiceList
+	err := r.list(ctx, cli, "services", &services)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var pods kubePodList
+	err = r.list(ctx, cli, "pods", &pods)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	objs := &kubeObjects{
+		services:        map[string]kubeService{},
+		pods:            map[string]kubePod{},
+		servicesVersion: services.Metadata.ResourceVersion,
+		podsVersion:     pods.Metadata.ResourceVersion,
+	}
+	for _, svc := range services.Items {
+		objs.services[svc.Metadata.UID] = svc
+	}
+	for _, pod := range pods.Items {
+		objs.pods[pod.Metadata.UID] = pod
+	}
+
+	return objs, nil
+}
+
+func (r *kubernetesContainerRepository) list(ctx context.Context, cli *kubeClient, resource string, list interface{}) error {
+	resp, err := cli.get(ctx, "/api/v1/"+resource)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	return errors.WithStack(json.NewDecoder(resp.Body).Decode(list))
+}
+
+// containers converts services into containers by their uids.
+// Ports of services without node ports are forwarded to their pods, and forwards no longer needed are closed.
+func (r *kubernetesContainerRepository) containers(objs *kubeObjects) map[string]domain.Container {
+	r.fm.Lock()
+	defer r.fm.Unlock()
+
+	containers := map[string]domain.Container{}
+	forwarded := map[string]bool{}
+
+	for uid := range objs.services {
+		svc := objs.services[uid]
+		log := r.log.With(zap.String("namespace", svc.Metadata.Namespace), zap.String("name", svc.Metadata.Name))
+
+		if ports := svc.nodePorts(); len(ports) > 0 {
+			if c, ok := svc.container(r.endpoint.Platform, nil, ports); ok {
+				containers[uid] = c
+			}
+			continue
+		}
+
+		pod := objs.pod(&svc)
+		if pod == nil {
+			log.Debug("skip a service without node ports nor ready pods")
+			continue
+		}
+		forwarded[uid] = true
+		if c, ok := svc.container(r.endpoint.Platform, kubeForwardHost, r.forward(&svc, pod)); ok {
+			containers[uid] = c
+		}
+	}
+
+	for uid, f := range r.forwards {
+		if !forwarded[uid] {
+			f.Close()
+			delete(r.forwards, uid)
+		}
+	}
+
+	return containers
+}
+
+// forward forwards TCP ports of the service to the pod, and returns local ports by service ports.
+// It should be called with fm locked.
+func (r *kubernetesContainerRepository) forward(svc *kubeService, pod *kubePod) map[int]int {
+	f, ok := r.forwards[svc.Metadata.UID]
+	if !ok {
+		f = newKubeServiceForward(svc.Metadata.Namespace, r.portForward, r.log)
+		r.forwards[svc.Metadata.UID] = f
+	}
+
+	ports := map[int]int{}
+	for _, p := range svc.Spec.Ports {
+		// port-forwarding supports TCP only
+		if p.Protocol != "" && p.Protocol != "TCP" {
+			continue
+		}
+		target, ok := pod.port(p.TargetPort, p.Port)
+		if !ok {
+			r.log.Debug("target port is not found", zap.String("pod", pod.Metadata.Name), zap.Int("port", p.Port))
+			continue
+		}
+		local, err := f.Forward(domain.Port(p.Port), kubePortForwardTarget{pod: pod.Metadata.Name, port: target})
+		if err != nil {
+			r.log.Warn("failed to forward a port", zap.String("name", svc.Metadata.Name), zap.Int("port", p.Port), zap.Error(err))
+			continue
+		}
+		ports[p.Port] = int(local)
+	}
+	return ports
+}
+
+// portForward connects to the port of the pod with a client for the current kubeconfig.
+func (r *kubernetesContainerRepository) portForward(namespace, pod string, port int) (io.ReadWriteCloser, error) {
+	cli, err := r.client()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cli.portForward(namespace, pod, port)
+}
+
+func (r *kubernetesContainerRepository) closeForwards() {
+	r.fm.Lock()
+	defer r.fm.Unlock()
+
+	for uid, f := range r.forwards {
+		f.Close()
+		delete(r.forwards, uid)
+	}
+}
+
+// ListenEvent emits events of services reachable from the host, including ones existing before listening.
+// When the connection is lost, it reconnects with exponential backoff and resyncs services.
+// Forwarded ports are closed when the context is canceled.
+func (r *kubernetesContainerRepository) ListenEvent(ctx context.Context) (<-chan domain.ContainerEvent, <-chan error) {
+	go func() {
+		<-ctx.Done()
+		r.closeForwards()
+	}()
+
+	known := map[string]domain.Container{}
+	return listenContainerEvents(ctx, r.endpoint, r.statusRepo, r.log, func(ctx context.Context, evCh chan<- domain.ContainerEvent) (bool, error) {
+		return r.streamEvents(ctx, evCh, known)
+	})
+}
+
+// streamEvents emits events until either watch of services or pods is closed.
+// known is a set of containers that have been emitted, it is updated with emitted events.
+func (r *kubernetesContainerRepository) streamEvents(ctx context.Context, evCh chan<- domain.ContainerEvent, known map[string]domain.Container) (connected bool, err error) {
+	cli, err := r.client()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	objs, err := r.listObjects(ctx, cli)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	err = r.sync(ctx, evCh, known, objs)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	// stop the other watch when either is closed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	watchCh := make(chan kubeWatchEvent)
+	watchErrCh := make(chan error, 2)
+
+	// watch changes after the listed versions not to miss objects created in the meantime
+	err = r.watch(ctx, cli, "services", objs.servicesVersion, watchCh, watchErrCh)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	err = r.watch(ctx, cli, "pods", objs.podsVersion, watchCh, watchErrCh)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	r.log.Info("connected to the container runtime", zap.Stringer("endpoint", r.endpoint))
+	setContainerStatus(ctx, r.statusRepo, r.log, r.endpoint, nil)
+
+	for {
+		var ev kubeWatchEvent
+		select {
+		case ev = <-watchCh:
+		case err := <-watchErrCh:
+			return true, errors.WithStack(err)
+		}
+
+		r.log.Debug("receive event", zap.String("resource", ev.resource), zap.String("type", ev.Type))
+
+		if ev.Type == "ERROR" {
+			// e.g. the resource version is too old, objects are listed again on reconnecting
+			return true, errors.Errorf("watch of %s returned an error: %s", ev.resource, ev.Object)
+		}
+
+		err = objs.update(&ev)
+		if err != nil {
+			return true, errors.WithStack(err)
+		}
+
+		err = r.sync(ctx, evCh, known, objs)
+		if err != nil {
+			return true, errors.WithStack(err)
+		}
+	}
+}
+
+// watch starts watching the resource after the version, and sends events to watchCh until the watch is closed.
+func (r *kubernetesContainerRepository) watch(ctx context.Context, cli *kubeClient, resource, version string, watchCh chan<- kubeWatchEvent, errCh chan<- error) error {
+	q := url.Values{}
+	q.Set("watch", "1")
+	q.Set("resourceVersion", version)
+	resp, err := cli.get(ctx, "/api/v1/"+resource+"?"+q.Encode())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	go func() {
+		defer resp.Body.Close()
+
+		dec := json.NewDecoder(resp.Body)
+		for {
+			ev := kubeWatchEvent{resource: resource}
+			err := dec.Decode(&ev)
+			if err != nil {
+				errCh <- errors.Wrapf(err, "watch of %s is closed", resource)
+				return
+			}
+			select {
+			case watchCh <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// sync emits events to make the known containers equal to ones converted from the objects.
+func (r *kubernetesContainerRepository) sync(ctx context.Context, evCh chan<- domain.ContainerEvent, known map[string]domain.Container, objs *kubeObjects) error {
+	containers := r.containers(objs)
+
+	ids := make([]string, 0, len(known)+len(containers))
+	for id := range known {
+		if _, ok := containers[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	for id := range containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		var c *domain.Container
+		if got, ok := containers[id]; ok {
+			c = &got
+		}
+		err := r.apply(ctx, evCh, known, id, c)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// apply emits events to make the known container with the id equal to c. c is nil if the container does not exist.
+func (r *kubernetesContainerRepository) apply(ctx context.Context, evCh chan<- domain.ContainerEvent, known map[string]domain.Container, id string, c *domain.Container) error {
+	prev, exists := known[id]
+	if (!exists && c == nil) || (exists && c != nil && reflect.DeepEqual(prev, *c)) {
+		return nil
+	}
+
+	ev := &domain.ContainerEvent{}
+	switch {
+	case c == nil:
+		delete(known, id)
+		ev.Type, ev.Container = domain.ContainerEventDestroyed, prev
+	case exists:
+		known[id] = *c
+		ev.Type, ev.Container = domain.ContainerEventUpdated, *c
+	default:
+		known[id] = *c
+		ev.Type, ev.Container = domain.ContainerEventCreated, *c
+	}
+
+	return errors.WithStack(emitContainerEvent(ctx, evCh, ev))
+}
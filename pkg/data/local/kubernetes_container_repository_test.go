@@ -0,0 +1,306 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+// fakeKubernetesResource is a resource served by the fake server.
+// items are listed at resourceVersion "10", and events are sent on watching after it.
+type fakeKubernetesResource struct {
+	items  []string
+	events []string
+}
+
+// newFakeKubernetesServer starts a server speaking a subset of the Kubernetes API.
+// It serves lists and watches of the resources, and echoes data sent to forwarded ports of pods.
+func newFakeKubernetesServer(t *testing.T, token string, resources map[string]fakeKubernetesResource) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer "+token; got != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/portforward") {
+			serveFakePortForward(t, w, r)
+			return
+		}
+		res, ok := resources[strings.TrimPrefix(r.URL.Path, "/api/v1/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("watch") == "" {
+			fmt.Fprintf(w, `{"metadata":{"resourceVersion":"10"},"items":[%s]}`, strings.Join(res.items, ","))
+			return
+		}
+
+		if got, want := r.URL.Query().Get("resourceVersion"), "10"; got != want {
+			t.Errorf("watch of %s started from %q, want %q", r.URL.Path, got, want)
+		}
+		for _, ev := range res.events {
+			fmt.Fprintln(w, ev)
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+// serveFakePortForward echoes data sent to the forwarded port over the port-forward protocol.
+func serveFakePortForward(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	websocket.Server{
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			if len(cfg.Protocol) != 1 || cfg.Protocol[0] != kubePortForwardProtocol {
+				return fmt.Errorf("unsupported protocols: %v", cfg.Protocol)
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			ws.PayloadType = websocket.BinaryFrame
+
+			port, _ := strconv.Atoi(r.URL.Query().Get("ports"))
+			for _, ch := range []byte{kubePortForwardDataChannel, kubePortForwardErrorChannel} {
+				if err := websocket.Message.Send(ws, []byte{ch, byte(port), byte(port >> 8)}); err != nil {
+					t.Errorf("failed to send a port number: %v", err)
+					return
+				}
+			}
+			for {
+				var msg []byte
+				if err := websocket.Message.Receive(ws, &msg); err != nil {
+					return
+				}
+				if err := websocket.Message.Send(ws, msg); err != nil {
+					return
+				}
+			}
+		},
+	}.ServeHTTP(w, r)
+}
+
+// writeKubeconfig writes a kubeconfig to access the server with the token, and returns its path.
+func writeKubeconfig(t *testing.T, dir, server, token string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "config")
+	err := ioutil.WriteFile(path, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: kind-ery
+clusters:
+- name: kind-ery
+  cluster:
+    server: %s
+contexts:
+- name: kind-ery
+  context:
+    cluster: kind-ery
+    user: kind-ery
+users:
+- name: kind-ery
+  user:
+    token: %s
+`, server, token)), 0644)
+	if err != nil {
+		t.Fatalf("failed to write kubeconfig: %v", err)
+	}
+	return path
+}
+
+// receiveContainerEvent receives an event from the channels of ListenEvent.
+func receiveContainerEvent(ctx context.Context, t *testing.T, evCh <-chan domain.ContainerEvent, errCh <-chan error) domain.ContainerEvent {
+	t.Helper()
+	select {
+	case ev := <-evCh:
+		return ev
+	case err := <-errCh:
+		t.Fatalf("ListenEvent returned an error: %v", err)
+	case <-ctx.Done():
+		t.Fatalf("timed out: %v", ctx.Err())
+	}
+	return domain.ContainerEvent{}
+}
+
+func TestKubernetesContainerRepository_ListenEvent(t *testing.T) {
+	// "web" has a node port, and "db" has neither node ports nor selectors.
+	web := `{"metadata":{"name":"web","namespace":"default","uid":"uid-web"},"spec":{"ports":[{"protocol":"TCP","port":80,"nodePort":30080}]}}`
+	db := `{"metadata":{"name":"db","namespace":"default","uid":"uid-db"},"spec":{"ports":[{"protocol":"TCP","port":5432}]}}`
+	api := `{"metadata":{"name":"api","namespace":"backend","uid":"uid-api"},"spec":{"ports":[{"protocol":"TCP","port":8080,"nodePort":30808}]}}`
+
+	srv := newFakeKubernetesServer(t, "secret", map[string]fakeKubernetesResource{
+		"services": {
+			items: []string{web, db},
+			events: []string{
+				`{"type":"ADDED","object":` + api + `}`,
+				`{"type":"DELETED","object":` + web + `}`,
+			},
+		},
+		"pods": {},
+	})
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ery")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kubeconfig := writeKubeconfig(t, dir, srv.URL, "secret")
+
+	endpoint := domain.ContainerEndpoint{Platform: domain.ContainerPlatformKubernetes, Host: kubeconfig}
+	statusRepo := NewStatusRepository()
+	repo := NewKubernetesContainerRepository(endpoint, statusRepo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	evCh, errCh := repo.ListenEvent(ctx)
+
+	cases := []struct {
+		typ       domain.ContainerEventType
+		id, name  string
+		namespace string
+		port      domain.Port
+		nodePort  domain.Port
+	}{
+		{typ: domain.ContainerEventCreated, id: "uid-web", name: "web", namespace: "default", port: 80, nodePort: 30080},
+		{typ: domain.ContainerEventCreated, id: "uid-api", name: "api", namespace: "backend", port: 8080, nodePort: 30808},
+		{typ: domain.ContainerEventDestroyed, id: "uid-web", name: "web", namespace: "default", port: 80, nodePort: 30080},
+	}
+
+	for _, tc := range cases {
+		ev := receiveContainerEvent(ctx, t, evCh, errCh)
+		c := ev.Container
+		if got, want := ev.Type, tc.typ; got != want {
+			t.Errorf("Type is %v, want %v", got, want)
+		}
+		if got, want := c.ID, tc.id; got != want {
+			t.Errorf("ID is %q, want %q", got, want)
+		}
+		if got, want := c.Name, tc.name; got != want {
+			t.Errorf("Name is %q, want %q", got, want)
+		}
+		if got, want := c.Platform, domain.ContainerPlatformKubernetes; got != want {
+			t.Errorf("Platform is %v, want %v", got, want)
+		}
+		if len(c.Networks) != 1 || c.Networks[0].Name != tc.namespace {
+			t.Errorf("Networks is %v, want [%s]", c.Networks, tc.namespace)
+		}
+		if got := c.PortBindings[tc.port]; len(got) != 1 || got[0] != tc.nodePort {
+			t.Errorf("PortBindings[%d] is %v, want [%d]", tc.port, got, tc.nodePort)
+		}
+	}
+
+	statuses, err := statusRepo.List(ctx)
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Component != endpoint.String() || !statuses[0].Healthy {
+		t.Errorf("statuses are %+v, want a healthy status of %s", statuses, endpoint)
+	}
+}
+
+func TestKubernetesContainerRepository_PortForward(t *testing.T) {
+	// "db" has no node ports, so its port is forwarded to the ready pod "db-0" by the name of the container port.
+	db := `{"metadata":{"name":"db","namespace":"default","uid":"uid-db"},"spec":{"selector":{"app":"db"},"ports":[{"name":"postgres","protocol":"TCP","port":5432,"targetPort":"postgres"}]}}`
+	pending := `{"metadata":{"name":"db-1","namespace":"default","uid":"uid-db-1","labels":{"app":"db"}},"status":{"phase":"Pending"}}`
+	pod := `{"metadata":{"name":"db-0","namespace":"default","uid":"uid-db-0","labels":{"app":"db"}},` +
+		`"spec":{"containers":[{"ports":[{"name":"postgres","containerPort":15432}]}]},` +
+		`"status":{"phase":"Running","conditions":[{"type":"Ready","status":"True"}]}}`
+
+	srv := newFakeKubernetesServer(t, "secret", map[string]fakeKubernetesResource{
+		"services": {items: []string{db}},
+		"pods": {
+			items:  []string{pending, pod},
+			events: []string{`{"type":"DELETED","object":` + pod + `}`},
+		},
+	})
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ery")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	endpoint := domain.ContainerEndpoint{Platform: domain.ContainerPlatformKubernetes, Host: writeKubeconfig(t, dir, srv.URL, "secret")}
+	repo := NewKubernetesContainerRepository(endpoint, NewStatusRepository())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	containers, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(containers) != 1 {
+		t.Fatalf("List returned %d containers, want 1", len(containers))
+	}
+	c := containers[0]
+	if got, want := c.HostIP.String(), "127.0.0.1"; got != want {
+		t.Errorf("HostIP is %s, want %s", got, want)
+	}
+	ports := c.PortBindings[5432]
+	if len(ports) != 1 {
+		t.Fatalf("PortBindings[5432] is %v, want a local port", ports)
+	}
+
+	t.Run("relay", func(t *testing.T) {
+		conn, err := net.Dial("tcp", net.JoinHostPort(c.HostIP.String(), strconv.Itoa(int(ports[0]))))
+		if err != nil {
+			t.Fatalf("failed to connect to the forwarded port: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte("ping"))
+		if err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		if got, want := string(buf), "ping"; got != want {
+			t.Errorf("received %q, want %q", got, want)
+		}
+	})
+
+	t.Run("pod deleted", func(t *testing.T) {
+		evCh, errCh := repo.ListenEvent(ctx)
+
+		ev := receiveContainerEvent(ctx, t, evCh, errCh)
+		if got, want := ev.Type, domain.ContainerEventCreated; got != want {
+			t.Errorf("Type is %v, want %v", got, want)
+		}
+		if got := ev.Container.PortBindings[5432]; len(got) != 1 || got[0] != ports[0] {
+			t.Errorf("PortBindings[5432] is %v, want %v", got, ports)
+		}
+
+		ev = receiveContainerEvent(ctx, t, evCh, errCh)
+		if got, want := ev.Type, domain.ContainerEventDestroyed; got != want {
+			t.Errorf("Type is %v, want %v", got, want)
+		}
+		if got, want := ev.Container.ID, "uid-db"; got != want {
+			t.Errorf("ID is %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package local
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+var (
+	// kubePortForwardProtocol is a WebSocket subprotocol of port-forwarding, messages are prefixed with channel numbers.
+	kubePortForwardProtocol = "v4.channel.k8s.io"
+	kubePortForwardTimeout  = 10 * time.Second
+	// kubeForwardHost is an address that forwarded ports listen on, they should not be exposed to the network.
+	kubeForwardHost = net.IPv4(127, 0, 0, 1)
+)
+
+// Channels of a forwarded port. The server sends the port number as the first message of each channel.
+const (
+	kubePortForwardDataChannel byte = iota
+	kubePortForwardErrorChannel
+)
+
+// portForward connects to the port of the pod through the API server.
+func (c *kubeClient) portForward(namespace, pod string, port int) (io.ReadWriteCloser, error) {
+	u, err := url.Parse(c.server)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = path.Join(u.Path, "/api/v1/namespaces", namespace, "pods", pod, "portforward")
+	u.RawQuery = url.Values{"ports": {strconv.Itoa(port)}}.Encode()
+
+	cfg, err := websocket.NewConfig(u.String(), c.server)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cfg.Protocol = []string{kubePortForwardProtocol}
+	cfg.TlsConfig = c.tls
+	cfg.Dialer = &net.Dialer{Timeout: kubePortForwardTimeout}
+	c.authorize(cfg.Header)
+
+	ws, err := websocket.DialConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to forward the port %d of %s/%s", port, namespace, pod)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+
+	return &kubePortForwardConn{ws: ws}, nil
+}
+
+// kubePortForwardConn is a connection to a forwarded port, its data and errors are multiplexed over a WebSocket.
+type kubePortForwardConn struct {
+	ws       *websocket.Conn
+	buf      []byte
+	received [2]bool // whether the port number has been received on each channel
+}
+
+func (c *kubePortForwardConn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		var msg []byte
+		if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+			return 0, err
+		}
+		if len(msg) == 0 || int(msg[0]) >= len(c.received) {
+			continue
+		}
+
+		ch, data := msg[0], msg[1:]
+		if !c.received[ch] {
+			c.received[ch] = true
+			if len(data) >= 2 {
+				data = data[2:]
+			}
+		}
+		if ch == kubePortForwardErrorChannel {
+			if len(data) > 0 {
+				return 0, errors.Errorf("port-forwarding failed: %s", data)
+			}
+			continue
+		}
+		c.buf = data
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *kubePortForwardConn) Write(p []byte) (int, error) {
+	msg := make([]byte, 0, len(p)+1)
+	msg = append(append(msg, kubePortForwardDataChannel), p...)
+	if err := websocket.Message.Send(c.ws, msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *kubePortForwardConn) Close() error {
+	return c.ws.Close()
+}
+
+// kubePortForwardTarget is a pod and its port that a service port is forwarded to.
+type kubePortForwardTarget struct {
+	pod  string
+	port int
+}
+
+// kubeServiceForward relays connections to local ports to pods backing a service.
+// Local ports are kept while the service exists, even if its pods are replaced.
+type kubeServiceForward struct {
+	namespace string
+	dial      func(namespace, pod string, port int) (io.ReadWriteCloser, error)
+	log       *zap.Logger
+
+	m         sync.Mutex
+	listeners map[domain.Port]net.Listener          // service port -> local listener
+	targets   map[domain.Port]kubePortForwardTarget // service port -> pod port
+}
+
+func newKubeServiceForward(namespace string, dial func(namespace, pod string, port int) (io.ReadWriteCloser, error), log *zap.Logger) *kubeServiceForward {
+	return &kubeServiceForward{
+		namespace: namespace,
+		dial:      dial,
+		log:       log,
+		listeners: map[domain.Port]net.Listener{},
+		targets:   map[domain.Port]kubePortForwardTarget{},
+	}
+}
+
+// Forward forwards the service port to the target, and returns the local port that relays connections to it.
+func (f *kubeServiceForward) Forward(port domain.Port, target kubePortForwardTarget) (domain.Port, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.targets[port] = target
+
+	l, ok := f.listeners[port]
+	if !ok {
+		var err error
+		l, err = net.Listen("tcp", net.JoinHostPort(kubeForwardHost.String(), "0"))
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		f.listeners[port] = l
+		go f.serve(l, port)
+	}
+
+	return domain.Port(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// Close stops listening on local ports. Relayed connections are kept until either end closes.
+func (f *kubeServiceForward) Close() {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	for port, l := range f.listeners {
+		l.Close()
+		delete(f.listeners, port)
+	}
+}
+
+func (f *kubeServiceForward) target(port domain.Port) kubePortForwardTarget {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.targets[port]
+}
+
+func (f *kubeServiceForward) serve(l net.Listener, port domain.Port) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go f.relay(conn, f.target(port))
+	}
+}
+
+func (f *kubeServiceForward) relay(conn net.Conn, target kubePortForwardTarget) {
+	defer conn.Close()
+
+	stream, err := f.dial(f.namespace, target.pod, target.port)
+	if err != nil {
+		f.log.Warn("failed to forward a connection", zap.String("namespace", f.namespace), zap.String("pod", target.pod), zap.Int("port", target.port), zap.Error(err))
+		return
+	}
+	defer stream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(stream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, stream)
+		done <- struct{}{}
+	}()
+	<-done
+}
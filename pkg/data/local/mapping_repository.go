@@ -3,20 +3,36 @@ package local
 import (
 	"context"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 
 	"github.com/srvc/ery/pkg/domain"
 	"github.com/srvc/ery/pkg/util/netutil"
 )
 
+const wildcardLabel = "*"
+
+// eventQueueSize is a number of events buffered for each listener.
+// Listeners are disconnected when they fall behind it, so slow listeners never block writes.
+var eventQueueSize = 256
+
 // NewMappingRepository creates a new MappingRepository instance that can access local data.
-func NewMappingRepository() domain.MappingRepository {
-	return &mappingRepositoryImpl{
+// Mappings are restored from the store and saved into it on every change if the store is not nil.
+// IPv6 loopback addresses are allocated to virtual hosts only if ipv6 is true, they should be assigned to the loopback interface.
+func NewMappingRepository(store MappingStore, ipv6 bool) domain.MappingRepository {
+	r := &mappingRepositoryImpl{
 		eventEmitters: new(sync.Map),
+		store:         store,
+		ipv6:          ipv6,
+		log:           zap.L().Named("mapping"),
 	}
+	r.restore()
+	return r
 }
 
 type mappingRepositoryImpl struct {
@@ -24,18 +40,50 @@ type mappingRepositoryImpl struct {
 	hosts             hosts
 	eventEmitters     *sync.Map
 	eventEmitterIDSeq uint64
+	replicaSeq        uint64 // for round robin across replicas
+	store             MappingStore
+	ipv6              bool
+	m                 sync.Mutex // serializes writes, mappings are replaced with updated copies
+	log               *zap.Logger
 }
 
 func (r *mappingRepositoryImpl) List(ctx context.Context) ([]*domain.Mapping, error) {
 	return r.mappingByHost.List(), nil
 }
 
+func (r *mappingRepositoryImpl) Get(ctx context.Context, host string) (*domain.Mapping, bool) {
+	return r.mappingByHost.Match(host)
+}
+
 func (r *mappingRepositoryImpl) LookupIP(ctx context.Context, host string) (net.IP, bool) {
-	return r.hosts.LookupIP(host)
+	return r.hosts.Match(host)
+}
+
+func (r *mappingRepositoryImpl) LookupIPv6(ctx context.Context, host string) (net.IP, bool) {
+	if !r.ipv6 {
+		return nil, false
+	}
+	ip, ok := r.hosts.Match(host)
+	if !ok {
+		return nil, false
+	}
+	return netutil.LoopbackAddrV6(ip), true
+}
+
+func (r *mappingRepositoryImpl) LookupHost(ctx context.Context, ip net.IP) (string, bool) {
+	if v4, ok := netutil.LoopbackAddrV4(ip); ok {
+		ip = v4
+	}
+	return r.hosts.LookupHost(ip)
 }
 
 func (r *mappingRepositoryImpl) MapAddr(ctx context.Context, addr domain.Addr) (domain.Addr, error) {
-	if m, ok := r.mappingByHost.Get(addr.Host); ok {
+	if m, ok := r.mappingByHost.Match(addr.Host); ok {
+		if replicas := m.Replicas[addr.Port]; len(replicas) > 0 {
+			i := atomic.AddUint64(&r.replicaSeq, 1)
+			rep := replicas[i%uint64(len(replicas))]
+			return domain.Addr{Host: rep.Host, Port: rep.Port}, nil
+		}
 		if got := m.Map(addr.Port); got.IsValid() {
 			return got, nil
 		}
@@ -43,16 +91,32 @@ func (r *mappingRepositoryImpl) MapAddr(ctx context.Context, addr domain.Addr) (
 	return domain.Addr{}, errors.Errorf("%v is not found", addr)
 }
 
-func (r *mappingRepositoryImpl) Create(ctx context.Context, lAddr domain.Addr, rPort domain.Port) (domain.Addr, error) {
+func (r *mappingRepositoryImpl) Create(ctx context.Context, lAddr domain.Addr, rPort domain.Port, opts ...domain.CreateOption) (domain.Addr, error) {
+	if err := validateHost(lAddr.Host); err != nil {
+		return domain.Addr{}, errors.WithStack(err)
+	}
+
+	o := domain.NewCreateOptions(opts...)
+	if err := o.Routes.Validate(); err != nil {
+		return domain.Addr{}, errors.WithStack(err)
+	}
+
+	r.m.Lock()
 	m, ok := r.mappingByHost.Get(lAddr.Host)
 	release := func() {}
 	if ok {
-		if _, ok = m.PortMap[lAddr.Port]; ok {
+		if _, registered := m.PortMap[lAddr.Port]; registered && !canAddReplica(m, lAddr.Port, o.ReplicaID) {
+			r.m.Unlock()
 			return domain.Addr{}, errors.Errorf("%v has already been registered", lAddr.Host)
 		}
+		m = m.Clone()
 	} else {
-		m = &domain.Mapping{VirtualHost: lAddr.Host, PortMap: domain.PortMap{}}
-		m.ProxyHost = r.hosts.GetIP(m.VirtualHost).String()
+		m = &domain.Mapping{VirtualHost: lAddr.Host, PortMap: domain.PortMap{}, Status: o.Status}
+		ip := r.hosts.GetIP(m.VirtualHost)
+		m.ProxyHost = ip.String()
+		if r.ipv6 {
+			m.ProxyHostV6 = netutil.LoopbackAddrV6(ip).String()
+		}
 		release = func() { r.hosts.Delete(m.VirtualHost) }
 	}
 
@@ -61,47 +125,332 @@ func (r *mappingRepositoryImpl) Create(ctx context.Context, lAddr domain.Addr, r
 		rPort, err = netutil.GetFreePort(m.ProxyHost)
 		if err != nil {
 			release()
+			r.m.Unlock()
 			return domain.Addr{}, errors.WithStack(err)
 		}
 	}
-	m.PortMap[lAddr.Port] = rPort
-
-	r.mappingByHost.Set(m.VirtualHost, m)
+	if _, registered := m.PortMap[lAddr.Port]; !registered {
+		m.PortMap[lAddr.Port] = rPort
+	}
+	if o.ReplicaID != "" {
+		if m.Replicas == nil {
+			m.Replicas = map[domain.Port][]domain.Replica{}
+		}
+		m.Replicas[lAddr.Port] = append(m.Replicas[lAddr.Port], domain.Replica{ID: o.ReplicaID, Host: o.TargetHost, Port: rPort})
+	}
+	if o.TargetHost != "" {
+		m.TargetHost = o.TargetHost
+	}
+	if o.Protocol != domain.ProtocolAuto {
+		if m.Protocols == nil {
+			m.Protocols = map[domain.Port]domain.Protocol{}
+		}
+		m.Protocols[lAddr.Port] = o.Protocol
+	}
+	if len(o.Routes) > 0 {
+		m.Routes = o.Routes
+	}
+	for k, v := range o.Meta {
+		if m.Meta == nil {
+			m.Meta = map[string]string{}
+		}
+		m.Meta[k] = v
+	}
+	if o.LeaseTTL > 0 {
+		m.Lease = domain.NewLease(o.LeaseTTL)
+	}
 
+	r.set(m)
+	r.save()
 	r.emitEvent(domain.MappingEvent{
 		Type:    domain.MappingEventCreated,
 		Mapping: *m,
 	})
+	r.m.Unlock()
+
+	if m.Lease != nil {
+		r.scheduleExpiry(m.VirtualHost, m.Lease)
+	}
 
 	return domain.Addr{Host: m.ProxyHost, Port: rPort}, nil
 }
 
+func (r *mappingRepositoryImpl) AddAlias(ctx context.Context, host, alias string) error {
+	if err := validateHost(alias); err != nil {
+		return errors.WithStack(err)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	m, ok := r.mappingByHost.Get(host)
+	if !ok {
+		return errors.Errorf("%s is not found", host)
+	}
+	if _, ok := r.mappingByHost.Get(alias); ok {
+		return errors.Errorf("%v has already been registered", alias)
+	}
+
+	m = m.Clone()
+	m.Aliases = append(m.Aliases, alias)
+	r.hosts.SetAlias(alias, m.VirtualHost)
+	r.set(m)
+	r.save()
+
+	r.emitEvent(domain.MappingEvent{
+		Type:    domain.MappingEventUpdated,
+		Mapping: *m,
+	})
+
+	return nil
+}
+
+func (r *mappingRepositoryImpl) RenewLease(ctx context.Context, host string) error {
+	r.m.Lock()
+
+	m, ok := r.mappingByHost.Get(host)
+	if !ok {
+		r.m.Unlock()
+		return errors.Errorf("%s is not found", host)
+	}
+	if m.Lease == nil {
+		r.m.Unlock()
+		return errors.Errorf("%s does not have a lease", host)
+	}
+
+	m = m.Clone()
+	m.Lease = domain.NewLease(m.Lease.TTL)
+	r.set(m)
+	r.save()
+	r.emitEvent(domain.MappingEvent{
+		Type:    domain.MappingEventUpdated,
+		Mapping: *m,
+	})
+	r.m.Unlock()
+
+	r.scheduleExpiry(m.VirtualHost, m.Lease)
+
+	return nil
+}
+
+func (r *mappingRepositoryImpl) UpdateStatus(ctx context.Context, host string, status domain.MappingStatus) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	m, ok := r.mappingByHost.Get(host)
+	if !ok {
+		return errors.Errorf("%s is not found", host)
+	}
+
+	m = m.Clone()
+	m.Status = status
+	r.set(m)
+	r.save()
+
+	r.emitEvent(domain.MappingEvent{
+		Type:    domain.MappingEventUpdated,
+		Mapping: *m,
+	})
+
+	return nil
+}
+
+func (r *mappingRepositoryImpl) UpdateRoutes(ctx context.Context, host string, routes domain.Routes) error {
+	if err := routes.Validate(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	m, ok := r.mappingByHost.Get(host)
+	if !ok {
+		return errors.Errorf("%s is not found", host)
+	}
+
+	m = m.Clone()
+	m.Routes = routes
+	r.set(m)
+	r.save()
+
+	r.emitEvent(domain.MappingEvent{
+		Type:    domain.MappingEventUpdated,
+		Mapping: *m,
+	})
+
+	return nil
+}
+
 func (r *mappingRepositoryImpl) DeleteByHost(ctx context.Context, host string) error {
-	if m, ok := r.mappingByHost.Get(host); ok {
-		r.mappingByHost.Delete(host)
+	r.m.Lock()
+
+	m, ok := r.mappingByHost.Get(host)
+	if !ok {
+		r.m.Unlock()
+		return nil
+	}
+
+	if m.VirtualHost != host {
+		m = r.deleteAlias(m, host)
+		r.save()
 		r.emitEvent(domain.MappingEvent{
-			Type:    domain.MappingEventDestroyed,
+			Type:    domain.MappingEventUpdated,
 			Mapping: *m,
 		})
+		r.m.Unlock()
+		return nil
 	}
+
+	r.delete(m)
+	r.save()
+	r.emitEvent(domain.MappingEvent{
+		Type:    domain.MappingEventDestroyed,
+		Mapping: *m,
+	})
+	r.m.Unlock()
+
+	return nil
+}
+
+// DeleteReplica removes targets of the replica. The mapping is deleted when it has no ports.
+// When only some ports are removed, a destroyed event having the removed ports is emitted so that their proxies are stopped.
+func (r *mappingRepositoryImpl) DeleteReplica(ctx context.Context, host, id string) error {
+	r.m.Lock()
+
+	orig, ok := r.mappingByHost.Get(host)
+	if !ok {
+		r.m.Unlock()
+		return nil
+	}
+
+	m := orig.Clone()
+	for port, replicas := range m.Replicas {
+		rest := make([]domain.Replica, 0, len(replicas))
+		for _, rep := range replicas {
+			if rep.ID != id {
+				rest = append(rest, rep)
+			}
+		}
+		if len(rest) == 0 {
+			delete(m.Replicas, port)
+			delete(m.PortMap, port)
+			delete(m.Protocols, port)
+			continue
+		}
+		m.Replicas[port] = rest
+		m.PortMap[port] = rest[0].Port
+	}
+
+	if len(m.PortMap) > 0 {
+		r.set(m)
+		r.save()
+		if len(m.PortMap) < len(orig.PortMap) {
+			removed := orig.Clone()
+			for port := range m.PortMap {
+				delete(removed.PortMap, port)
+			}
+			r.emitEvent(domain.MappingEvent{Type: domain.MappingEventDestroyed, Mapping: *removed})
+			// proxies derived from the rest of ports, such as HTTPS for HTTP, may have been stopped with the removed ports
+			r.emitEvent(domain.MappingEvent{Type: domain.MappingEventCreated, Mapping: *m})
+		}
+		r.m.Unlock()
+		return nil
+	}
+
+	r.delete(orig)
+	r.save()
+	r.emitEvent(domain.MappingEvent{
+		Type:    domain.MappingEventDestroyed,
+		Mapping: *orig,
+	})
+	r.m.Unlock()
+
 	return nil
 }
 
+// scheduleExpiry deletes the mapping when the lease expires.
+// Renewed leases are checked again at the time, so timers of older leases do nothing.
+func (r *mappingRepositoryImpl) scheduleExpiry(host string, l *domain.Lease) {
+	time.AfterFunc(l.Remaining(), func() { r.expire(host) })
+}
+
+func (r *mappingRepositoryImpl) expire(host string) {
+	r.m.Lock()
+
+	m, ok := r.mappingByHost.Get(host)
+	if !ok || m.VirtualHost != host || m.Lease == nil || !m.Lease.Expired() {
+		r.m.Unlock()
+		return
+	}
+
+	r.log.Info("lease has expired", zap.String("host", host), zap.Duration("ttl", m.Lease.TTL))
+	r.delete(m)
+	r.save()
+	r.emitEvent(domain.MappingEvent{
+		Type:    domain.MappingEventDestroyed,
+		Mapping: *m,
+	})
+	r.m.Unlock()
+}
+
+// delete removes the mapping for its virtual host and aliases.
+func (r *mappingRepositoryImpl) delete(m *domain.Mapping) {
+	for _, alias := range m.Aliases {
+		r.mappingByHost.Delete(alias)
+		r.hosts.Delete(alias)
+	}
+	r.mappingByHost.Delete(m.VirtualHost)
+}
+
+// deleteAlias removes the alias from the mapping, and returns the updated mapping.
+func (r *mappingRepositoryImpl) deleteAlias(m *domain.Mapping, alias string) *domain.Mapping {
+	m = m.Clone()
+	aliases := make([]string, 0, len(m.Aliases))
+	for _, a := range m.Aliases {
+		if a != alias {
+			aliases = append(aliases, a)
+		}
+	}
+	m.Aliases = aliases
+	r.mappingByHost.Delete(alias)
+	r.hosts.Delete(alias)
+	r.set(m)
+	return m
+}
+
+// set stores the mapping for its virtual host and aliases.
+func (r *mappingRepositoryImpl) set(m *domain.Mapping) {
+	r.mappingByHost.Set(m.VirtualHost, m)
+	for _, alias := range m.Aliases {
+		r.mappingByHost.Set(alias, m)
+	}
+}
+
 func (r *mappingRepositoryImpl) ListenEvent(ctx context.Context) (<-chan domain.MappingEvent, <-chan error) {
-	evCh := make(chan domain.MappingEvent)
+	evCh := make(chan domain.MappingEvent, eventQueueSize)
 	errCh := make(chan error, 1)
 
 	id := atomic.AddUint64(&r.eventEmitterIDSeq, 1)
-	r.eventEmitters.Store(id, &mappingEventEmitter{
+	emitter := &mappingEventEmitter{
 		id:    id,
 		evCh:  evCh,
 		errCh: errCh,
 		ctx:   ctx,
-	})
+	}
+	r.eventEmitters.Store(id, emitter)
+
+	// unregister the emitter as soon as the listener has gone, not to keep it until the next event
+	go func() {
+		<-ctx.Done()
+		emitter.fail(ctx.Err())
+		r.eventEmitters.Delete(id)
+	}()
 
 	return evCh, errCh
 }
 
+// emitEvent queues the event for each listener.
+// It should be called with m locked, so that listeners receive events in the order of changes. It never blocks.
 func (r *mappingRepositoryImpl) emitEvent(ev domain.MappingEvent) {
 	var disposableIDs []uint64
 	r.eventEmitters.Range(func(_, v interface{}) bool {
@@ -128,13 +477,24 @@ func (m *mappingByHost) Get(host string) (out *domain.Mapping, ok bool) {
 	return
 }
 
+// Match returns a mapping for the host. Exact matches take precedence over wildcard hosts.
+func (m *mappingByHost) Match(host string) (out *domain.Mapping, ok bool) {
+	for _, h := range matchingHosts(host) {
+		if out, ok = m.Get(h); ok {
+			return
+		}
+	}
+	return
+}
+
 func (m *mappingByHost) Set(host string, in *domain.Mapping) {
 	m.m.Store(host, in)
 }
 
 func (m *mappingByHost) List() (out []*domain.Mapping) {
-	m.m.Range(func(_, v interface{}) bool {
-		if m, ok := v.(*domain.Mapping); ok {
+	m.m.Range(func(k, v interface{}) bool {
+		// skip entries for aliases
+		if m, ok := v.(*domain.Mapping); ok && m.VirtualHost == k {
 			out = append(out, m)
 		}
 		return true
@@ -148,7 +508,7 @@ func (m *mappingByHost) Delete(host string) {
 
 type hosts struct {
 	m     sync.Map
-	ipSet sync.Map
+	ipSet sync.Map // reverse index: ip -> host
 }
 
 func (h *hosts) GetIP(host string) net.IP {
@@ -158,13 +518,19 @@ func (h *hosts) GetIP(host string) net.IP {
 	for {
 		ip := netutil.RandomLoopbackAddr()
 		if _, ok := h.ipSet.Load(ip.String()); !ok {
-			h.ipSet.Store(ip.String(), struct{}{})
+			h.ipSet.Store(ip.String(), host)
 			h.m.Store(host, ip)
 			return ip
 		}
 	}
 }
 
+// Restore stores the host and the IP address allocated before.
+func (h *hosts) Restore(host string, ip net.IP) {
+	h.ipSet.Store(ip.String(), host)
+	h.m.Store(host, ip)
+}
+
 func (h *hosts) LookupIP(host string) (ip net.IP, ok bool) {
 	var v interface{}
 	if v, ok = h.m.Load(host); ok {
@@ -173,27 +539,103 @@ func (h *hosts) LookupIP(host string) (ip net.IP, ok bool) {
 	return
 }
 
+// Match returns an IP address for the host. Exact matches take precedence over wildcard hosts.
+func (h *hosts) Match(host string) (ip net.IP, ok bool) {
+	for _, n := range matchingHosts(host) {
+		if ip, ok = h.LookupIP(n); ok {
+			return
+		}
+	}
+	return
+}
+
+func (h *hosts) LookupHost(ip net.IP) (host string, ok bool) {
+	var v interface{}
+	if v, ok = h.ipSet.Load(ip.String()); ok {
+		host, ok = v.(string)
+	}
+	return
+}
+
+// SetAlias makes the alias share an IP address with the host.
+// The reverse index keeps pointing the host.
+func (h *hosts) SetAlias(alias, host string) {
+	if ip, ok := h.LookupIP(host); ok {
+		h.m.Store(alias, ip)
+	}
+}
+
 func (h *hosts) Delete(host string) {
 	if ip, ok := h.LookupIP(host); ok {
-		h.ipSet.Delete(ip.String())
+		if owner, ok := h.LookupHost(ip); ok && owner == host {
+			h.ipSet.Delete(ip.String())
+		}
 	}
 	h.m.Delete(host)
 }
 
 type mappingEventEmitter struct {
-	id    uint64
-	evCh  chan<- domain.MappingEvent
-	errCh chan<- error
-	ctx   context.Context
+	id      uint64
+	evCh    chan<- domain.MappingEvent
+	errCh   chan<- error
+	ctx     context.Context
+	errOnce sync.Once
 }
 
+// Emit queues the event without blocking. It returns false if the listener has gone or fallen behind.
 func (e *mappingEventEmitter) Emit(ev domain.MappingEvent) bool {
-	select {
-	case <-e.ctx.Done():
-		e.errCh <- e.ctx.Err()
+	if err := e.ctx.Err(); err != nil {
+		e.fail(err)
 		return false
-	default:
-		e.evCh <- ev
+	}
+	select {
+	case e.evCh <- ev:
 		return true
+	default:
+		e.fail(errors.Errorf("listener has fallen behind %d mapping events", eventQueueSize))
+		return false
 	}
 }
+
+// fail reports the error only once, emitters can be called concurrently until they are removed.
+func (e *mappingEventEmitter) fail(err error) {
+	e.errOnce.Do(func() {
+		e.errCh <- err
+	})
+}
+
+// matchingHosts returns the host and wildcard hosts that match it, in order of precedence.
+// e.g. "a.myapp.ery" -> ["a.myapp.ery", "*.myapp.ery", "*.ery"]
+func matchingHosts(host string) []string {
+	hosts := []string{host}
+	labels := strings.Split(host, ".")
+	for i := 1; i < len(labels); i++ {
+		hosts = append(hosts, strings.Join(append([]string{wildcardLabel}, labels[i:]...), "."))
+	}
+	return hosts
+}
+
+// canAddReplica returns true if the port is shared by replicas and the replica has not been registered yet.
+func canAddReplica(m *domain.Mapping, port domain.Port, id string) bool {
+	replicas := m.Replicas[port]
+	if id == "" || len(replicas) == 0 {
+		return false
+	}
+	for _, rep := range replicas {
+		if rep.ID == id {
+			return false
+		}
+	}
+	return true
+}
+
+// validateHost returns an error if the host has wildcards on other than the leftmost label.
+func validateHost(host string) error {
+	if host == "" {
+		return errors.New("host should not be empty")
+	}
+	if n := strings.Count(host, wildcardLabel); n > 1 || (n == 1 && !strings.HasPrefix(host, wildcardLabel+".")) {
+		return errors.Errorf("%s is invalid, wildcards are allowed only as the leftmost label", host)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+func TestMappingRepository_ListenEvent_Disconnected(t *testing.T) {
+	defer func(n int) { eventQueueSize = n }(eventQueueSize)
+	eventQueueSize = 2
+
+	cases := []struct {
+		test   string
+		cancel bool
+	}{
+		{test: "listener has gone", cancel: true},
+		{test: "listener does not receive events"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.test, func(t *testing.T) {
+			repo := NewMappingRepository(nil, false)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, errCh := repo.ListenEvent(ctx)
+			if c.cancel {
+				cancel()
+			}
+
+			// writes never block on the listener
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 2*eventQueueSize+1; i++ {
+					_, err := repo.Create(context.Background(), domain.HTTPAddr(fmt.Sprintf("app%d.ery", i)), domain.Port(10000+i))
+					if err != nil {
+						t.Errorf("Create returned an error: %v", err)
+					}
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Create is blocked by the listener")
+			}
+
+			select {
+			case err := <-errCh:
+				if err == nil {
+					t.Error("listener should receive an error")
+				}
+			default:
+				t.Error("listener should be notified that it is disconnected")
+			}
+		})
+	}
+}
+
+func TestMappingRepository_DeleteReplica(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := NewMappingRepository(nil, false)
+	// replica "a" serves ports 80 and 8080, and "b" serves only 80
+	for _, c := range []struct {
+		id         string
+		port, dest domain.Port
+	}{
+		{id: "a", port: 80, dest: 10080},
+		{id: "a", port: 8080, dest: 18080},
+		{id: "b", port: 80, dest: 20080},
+	} {
+		_, err := repo.Create(ctx, domain.Addr{Host: "web.ery", Port: c.port}, c.dest, domain.WithReplica(c.id))
+		if err != nil {
+			t.Fatalf("Create returned an error: %v", err)
+		}
+	}
+
+	evCh, _ := repo.ListenEvent(ctx)
+
+	err := repo.DeleteReplica(ctx, "web.ery", "a")
+	if err != nil {
+		t.Fatalf("DeleteReplica returned an error: %v", err)
+	}
+
+	m, ok := repo.Get(ctx, "web.ery")
+	if !ok {
+		t.Fatal("mapping should remain for the replica b")
+	}
+	if got, want := m.PortMap, (domain.PortMap{80: 20080}); !reflect.DeepEqual(got, want) {
+		t.Errorf("PortMap is %v, want %v", got, want)
+	}
+
+	wants := []struct {
+		typ     domain.MappingEventType
+		portMap domain.PortMap
+	}{
+		{typ: domain.MappingEventDestroyed, portMap: domain.PortMap{8080: 18080}},
+		{typ: domain.MappingEventCreated, portMap: domain.PortMap{80: 20080}},
+	}
+	for _, want := range wants {
+		select {
+		case ev := <-evCh:
+			if ev.Type != want.typ || !reflect.DeepEqual(ev.PortMap, want.portMap) {
+				t.Errorf("received %s event having %v, want %s event having %v", ev.Type, ev.PortMap, want.typ, want.portMap)
+			}
+		default:
+			t.Fatalf("%s event should be emitted", want.typ)
+		}
+	}
+}
+
+func TestMappingRepository_Lease(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ttl := 100 * time.Millisecond
+	repo := NewMappingRepository(nil, false)
+	evCh, _ := repo.ListenEvent(ctx)
+
+	_, err := repo.Create(ctx, domain.HTTPAddr("myapp.ery"), 3000, domain.WithLease(ttl))
+	if err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+	_, err = repo.Create(ctx, domain.HTTPAddr("static.ery"), 3001)
+	if err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		<-evCh
+	}
+
+	// renewed leases keep the mapping beyond the first ttl
+	for i := 0; i < 4; i++ {
+		time.Sleep(ttl / 2)
+		if err := repo.RenewLease(ctx, "myapp.ery"); err != nil {
+			t.Fatalf("RenewLease returned an error: %v", err)
+		}
+	}
+	if _, ok := repo.Get(ctx, "myapp.ery"); !ok {
+		t.Fatal("mapping should not expire while its lease is renewed")
+	}
+
+	timeout := time.After(10 * ttl)
+	for expired := false; !expired; {
+		select {
+		case ev := <-evCh:
+			if ev.Type == domain.MappingEventUpdated {
+				// renewed
+				continue
+			}
+			if ev.Type != domain.MappingEventDestroyed || ev.VirtualHost != "myapp.ery" {
+				t.Errorf("received %s event of %s, want destroyed event of myapp.ery", ev.Type, ev.VirtualHost)
+			}
+			expired = true
+		case <-timeout:
+			t.Fatal("mapping should expire after the lease is no longer renewed")
+		}
+	}
+	if _, ok := repo.Get(ctx, "myapp.ery"); ok {
+		t.Error("expired mapping should be deleted")
+	}
+	if _, ok := repo.Get(ctx, "static.ery"); !ok {
+		t.Error("mapping without a lease should never expire")
+	}
+
+	for _, host := range []string{"myapp.ery", "static.ery", "unknown.ery"} {
+		if err := repo.RenewLease(ctx, host); err == nil {
+			t.Errorf("RenewLease should return an error for %s", host)
+		}
+	}
+}
+
+func TestMappingRepository_ListenEvent_Updated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := NewMappingRepository(nil, false)
+	_, err := repo.Create(ctx, domain.HTTPAddr("myapp.ery"), 3000, domain.WithLease(time.Minute))
+	if err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+
+	evCh, _ := repo.ListenEvent(ctx)
+
+	cases := []struct {
+		test   string
+		update func() error
+		check  func(m *domain.Mapping) bool
+	}{
+		{
+			test:   "AddAlias",
+			update: func() error { return repo.AddAlias(ctx, "myapp.ery", "alias.ery") },
+			check:  func(m *domain.Mapping) bool { return reflect.DeepEqual(m.Aliases, []string{"alias.ery"}) },
+		},
+		{
+			test:   "UpdateStatus",
+			update: func() error { return repo.UpdateStatus(ctx, "myapp.ery", domain.MappingStatusStarting) },
+			check:  func(m *domain.Mapping) bool { return m.Status == domain.MappingStatusStarting },
+		},
+		{
+			test: "UpdateRoutes",
+			update: func() error {
+				return repo.UpdateRoutes(ctx, "myapp.ery", domain.Routes{{PathPrefix: "/api", Target: domain.HTTPAddr("api.ery")}})
+			},
+			check: func(m *domain.Mapping) bool { return len(m.Routes) == 1 },
+		},
+		{
+			test:   "RenewLease",
+			update: func() error { return repo.RenewLease(ctx, "myapp.ery") },
+			check:  func(m *domain.Mapping) bool { return m.Lease != nil },
+		},
+		{
+			test:   "DeleteByHost with an alias",
+			update: func() error { return repo.DeleteByHost(ctx, "alias.ery") },
+			check:  func(m *domain.Mapping) bool { return len(m.Aliases) == 0 },
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.test, func(t *testing.T) {
+			if err := c.update(); err != nil {
+				t.Fatalf("%s returned an error: %v", c.test, err)
+			}
+			select {
+			case ev := <-evCh:
+				if ev.Type != domain.MappingEventUpdated || ev.VirtualHost != "myapp.ery" {
+					t.Errorf("received %s event of %s, want updated event of myapp.ery", ev.Type, ev.VirtualHost)
+				}
+				if !c.check(&ev.Mapping) {
+					t.Errorf("event has a mapping before the update: %+v", ev.Mapping)
+				}
+			default:
+				t.Fatal("updated event should be emitted")
+			}
+		})
+	}
+}
+
+func TestMappingRepository_ListenEvent_Unregistered(t *testing.T) {
+	repo := NewMappingRepository(nil, false).(*mappingRepositoryImpl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, errCh := repo.ListenEvent(ctx)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("listener should receive an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listener should be notified that it is disconnected")
+	}
+
+	// the emitter is removed without emitting events
+	for i := 0; i < 100; i++ {
+		registered := false
+		repo.eventEmitters.Range(func(_, _ interface{}) bool {
+			registered = true
+			return false
+		})
+		if !registered {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("emitter should be unregistered when the context is canceled")
+}
+
+func TestMappingRepository_ListenEvent_Ordered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := NewMappingRepository(nil, false)
+	evCh, errCh := repo.ListenEvent(ctx)
+
+	// writers race to create and delete the same host, the listener should see them alternately.
+	// they emit at most eventQueueSize events, so the listener never falls behind.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < eventQueueSize/2; i++ {
+			repo.Create(ctx, domain.HTTPAddr("myapp.ery"), 3000)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < eventQueueSize/2; i++ {
+			repo.DeleteByHost(ctx, "myapp.ery")
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	created := false
+	check := func(ev domain.MappingEvent) {
+		t.Helper()
+		switch {
+		case ev.Type == domain.MappingEventCreated && created:
+			t.Fatal("received a created event after another created event")
+		case ev.Type == domain.MappingEventDestroyed && !created:
+			t.Fatal("received a destroyed event of a mapping not created")
+		}
+		created = ev.Type == domain.MappingEventCreated
+	}
+
+	for {
+		select {
+		case ev := <-evCh:
+			check(ev)
+		case err := <-errCh:
+			t.Fatalf("listener is disconnected: %v", err)
+		case <-done:
+			for {
+				select {
+				case ev := <-evCh:
+					check(ev)
+				default:
+					if _, ok := repo.Get(ctx, "myapp.ery"); ok != created {
+						t.Errorf("last event says the mapping exists: %v, but it does: %v", created, ok)
+					}
+					return
+				}
+			}
+		}
+	}
+}
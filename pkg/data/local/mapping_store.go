@@ -0,0 +1,160 @@
+package local
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+
+	"github.com/srvc/ery/pkg/domain"
+	"github.com/srvc/ery/pkg/util/netutil"
+	"github.com/srvc/ery/pkg/util/procutil"
+)
+
+// MappingStore is an interface for persisting mappings across restarts.
+type MappingStore interface {
+	Load() ([]*domain.Mapping, error)
+	Save([]*domain.Mapping) error
+}
+
+// NewFileMappingStore creates a new MappingStore instance that saves mappings into a JSON file.
+func NewFileMappingStore(fs afero.Fs, path string) MappingStore {
+	return &fileMappingStore{
+		fs:   fs,
+		path: path,
+	}
+}
+
+type fileMappingStore struct {
+	fs   afero.Fs
+	path string
+	m    sync.Mutex
+}
+
+type mappingState struct {
+	Mappings []*domain.Mapping `json:"mappings"`
+}
+
+func (s *fileMappingStore) Load() ([]*domain.Mapping, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	data, err := afero.ReadFile(s.fs, s.path)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var state mappingState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", s.path)
+	}
+
+	return state.Mappings, nil
+}
+
+func (s *fileMappingStore) Save(mappings []*domain.Mapping) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	data, err := json.Marshal(mappingState{Mappings: mappings})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.fs.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// write into a temporary file and rename it to avoid leaving a broken file
+	tmp := s.path + ".tmp"
+	err = afero.WriteFile(s.fs, tmp, data, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(s.fs.Rename(tmp, s.path))
+}
+
+// restore loads mappings from the store.
+// Mappings owned by exited processes and ones without owners (they will be re-created by their creators) are dropped.
+// Mappings owned by containers (including replicated ones) are kept, the container watcher reconciles them with running containers.
+func (r *mappingRepositoryImpl) restore() {
+	if r.store == nil {
+		return
+	}
+
+	mappings, err := r.store.Load()
+	if err != nil {
+		r.log.Warn("failed to restore mappings", zap.Error(err))
+		return
+	}
+
+	for _, m := range mappings {
+		if !isRestorable(m) {
+			r.log.Info("drop a stale mapping", zap.String("host", m.VirtualHost), zap.Any("meta", m.Meta))
+			continue
+		}
+
+		ip := net.ParseIP(m.ProxyHost)
+		if ip == nil {
+			r.log.Warn("drop a mapping having an invalid proxy host", zap.String("host", m.VirtualHost), zap.String("proxy_host", m.ProxyHost))
+			continue
+		}
+
+		r.hosts.Restore(m.VirtualHost, ip)
+		// IPv6 may be enabled or disabled since the mapping was saved
+		m.ProxyHostV6 = ""
+		if r.ipv6 {
+			m.ProxyHostV6 = netutil.LoopbackAddrV6(ip).String()
+		}
+		for _, alias := range m.Aliases {
+			r.hosts.SetAlias(alias, m.VirtualHost)
+		}
+		if m.Lease != nil {
+			// owners could not renew leases while the daemon was stopped, so give them a grace period
+			m.Lease = domain.NewLease(m.Lease.TTL)
+			r.scheduleExpiry(m.VirtualHost, m.Lease)
+		}
+		r.set(m)
+		r.log.Info("restored a mapping", zap.String("host", m.VirtualHost), zap.Any("port_map", m.PortMap))
+	}
+
+	r.save()
+}
+
+// save writes current mappings into the store. It should be called with the lock.
+func (r *mappingRepositoryImpl) save() {
+	if r.store == nil {
+		return
+	}
+
+	err := r.store.Save(r.mappingByHost.List())
+	if err != nil {
+		r.log.Warn("failed to save mappings", zap.Error(err))
+	}
+}
+
+func isRestorable(m *domain.Mapping) bool {
+	if _, ok := m.Meta[domain.MetaContainerID]; ok {
+		return true
+	}
+	if len(m.Replicas) > 0 {
+		return true
+	}
+	if v, ok := m.Meta[domain.MetaPID]; ok {
+		pid, err := strconv.Atoi(v)
+		return err == nil && procutil.Alive(pid)
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package local
+
+import (
+	"context"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+func TestFileMappingStore(t *testing.T) {
+	store := NewFileMappingStore(afero.NewMemMapFs(), "/var/lib/ery/state.json")
+
+	got, err := store.Load()
+	if err != nil || got != nil {
+		t.Fatalf("Load should return nothing before saving, but returned (%v, %v)", got, err)
+	}
+
+	mappings := []*domain.Mapping{
+		{VirtualHost: "myapp.ery", ProxyHost: "127.0.0.5", PortMap: domain.PortMap{80: 3000}, Meta: map[string]string{domain.MetaPID: "1"}},
+	}
+	err = store.Save(mappings)
+	if err != nil {
+		t.Fatalf("Save returned an error: %v", err)
+	}
+
+	got, err = store.Load()
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(got, mappings) {
+		t.Errorf("Load returned %v, want %v", got, mappings)
+	}
+}
+
+func TestMappingRepository_restore(t *testing.T) {
+	// pids are up to 2^22 on Linux
+	deadPID := strconv.Itoa(1 << 30)
+	alivePID := strconv.Itoa(os.Getpid())
+
+	cases := []struct {
+		mapping  domain.Mapping
+		restored bool
+	}{
+		{
+			mapping:  domain.Mapping{VirtualHost: "container.ery", ProxyHost: "127.0.0.5", Meta: map[string]string{domain.MetaContainerID: "c0ffee"}},
+			restored: true,
+		},
+		{
+			mapping: domain.Mapping{
+				VirtualHost: "replicas.ery",
+				ProxyHost:   "127.0.0.6",
+				Replicas:    map[domain.Port][]domain.Replica{80: {{ID: "c0ffee", Port: 32768}}},
+			},
+			restored: true,
+		},
+		{
+			mapping:  domain.Mapping{VirtualHost: "alive.ery", ProxyHost: "127.0.0.7", Aliases: []string{"www.alive.ery"}, Meta: map[string]string{domain.MetaPID: alivePID}},
+			restored: true,
+		},
+		{
+			mapping: domain.Mapping{VirtualHost: "dead.ery", ProxyHost: "127.0.0.8", Meta: map[string]string{domain.MetaPID: deadPID}},
+		},
+		{
+			mapping: domain.Mapping{VirtualHost: "unowned.ery", ProxyHost: "127.0.0.9"},
+		},
+		{
+			mapping: domain.Mapping{VirtualHost: "invalid.ery", ProxyHost: "localhost", Meta: map[string]string{domain.MetaContainerID: "c0ffee"}},
+		},
+	}
+
+	for _, ipv6 := range []bool{false, true} {
+		t.Run("ipv6="+strconv.FormatBool(ipv6), func(t *testing.T) {
+			ctx := context.Background()
+
+			store := NewFileMappingStore(afero.NewMemMapFs(), "/state.json")
+			var saved []*domain.Mapping
+			for _, c := range cases {
+				m := c.mapping
+				m.ProxyHostV6 = "fd65:7279::ff" // saved with IPv6 enabled
+				saved = append(saved, &m)
+			}
+			if err := store.Save(saved); err != nil {
+				t.Fatalf("Save returned an error: %v", err)
+			}
+
+			repo := NewMappingRepository(store, ipv6)
+
+			var restored []string
+			for _, c := range cases {
+				m, ok := repo.Get(ctx, c.mapping.VirtualHost)
+				if ok != c.restored {
+					t.Errorf("%s is restored: %t, want %t", c.mapping.VirtualHost, ok, c.restored)
+				}
+				if !ok {
+					continue
+				}
+				restored = append(restored, m.VirtualHost)
+
+				// addresses are kept not to change ones resolved before restarting
+				ip, ok := repo.LookupIP(ctx, c.mapping.VirtualHost)
+				if !ok || !ip.Equal(net.ParseIP(c.mapping.ProxyHost)) {
+					t.Errorf("%s is resolved to %v, want %s", c.mapping.VirtualHost, ip, c.mapping.ProxyHost)
+				}
+				_, ok = repo.LookupIPv6(ctx, c.mapping.VirtualHost)
+				if ok != ipv6 {
+					t.Errorf("%s has an IPv6 address: %t, want %t", c.mapping.VirtualHost, ok, ipv6)
+				}
+				for _, alias := range c.mapping.Aliases {
+					if _, ok := repo.Get(ctx, alias); !ok {
+						t.Errorf("alias %s should be restored", alias)
+					}
+				}
+			}
+
+			// dropped mappings are removed from the store
+			got, err := store.Load()
+			if err != nil {
+				t.Fatalf("Load returned an error: %v", err)
+			}
+			if len(got) != len(restored) {
+				t.Errorf("store has %d mappings, want %v", len(got), restored)
+			}
+		})
+	}
+}
+
+func TestMappingRepository_restore_Lease(t *testing.T) {
+	store := NewFileMappingStore(afero.NewMemMapFs(), "/state.json")
+	err := store.Save([]*domain.Mapping{{
+		VirtualHost: "myapp.ery",
+		ProxyHost:   "127.0.0.5",
+		Meta:        map[string]string{domain.MetaPID: strconv.Itoa(os.Getpid())},
+		Lease:       &domain.Lease{TTL: time.Minute, ExpiresAt: time.Now().Add(-time.Hour)},
+	}})
+	if err != nil {
+		t.Fatalf("Save returned an error: %v", err)
+	}
+
+	repo := NewMappingRepository(store, false)
+
+	// owners could not renew leases while the daemon was stopped
+	m, ok := repo.Get(context.Background(), "myapp.ery")
+	if !ok {
+		t.Fatal("mapping should be restored")
+	}
+	if m.Lease.Expired() || m.Lease.Remaining() > time.Minute {
+		t.Errorf("lease should be renewed with its TTL, but expires at %v", m.Lease.ExpiresAt)
+	}
+}
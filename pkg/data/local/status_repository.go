@@ -0,0 +1,49 @@
+package local
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+// NewStatusRepository creates a new StatusRepository instance that keeps statuses in memory.
+func NewStatusRepository() domain.StatusRepository {
+	return &statusRepositoryImpl{
+		statusByComponent: map[string]*domain.Status{},
+	}
+}
+
+type statusRepositoryImpl struct {
+	statusByComponent map[string]*domain.Status
+	m                 sync.RWMutex
+}
+
+func (r *statusRepositoryImpl) List(ctx context.Context) ([]*domain.Status, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	statuses := make([]*domain.Status, 0, len(r.statusByComponent))
+	for _, s := range r.statusByComponent {
+		copied := *s
+		statuses = append(statuses, &copied)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Component < statuses[j].Component })
+
+	return statuses, nil
+}
+
+func (r *statusRepositoryImpl) Set(ctx context.Context, status *domain.Status) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	copied := *status
+	if copied.UpdatedAt.IsZero() {
+		copied.UpdatedAt = time.Now()
+	}
+	r.statusByComponent[copied.Component] = &copied
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+
+	"github.com/srvc/ery/pkg/domain"
+)
+
+// NewCertificateRepository creates a new CertificateRepository instance that can access remote data.
+// Only the CA certificate is available, private keys of virtual hosts never leave the server.
+func NewCertificateRepository(url *url.URL, client *http.Client) domain.CertificateRepository {
+	return &certificateRepositoryImpl{
+		baseURL: url,
+		client:  client,
+	}
+}
+
+type certificateRepositoryImpl struct {
+	baseURL *url.URL
+	client  *http.Client
+}
+
+func (r *certificateRepositoryImpl) GetCACertificate(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequest("GET", r.baseURL.String()+"/certificates/ca", nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to get the CA certificate: %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return data, nil
+}
+
+func (r *certificateRepositoryImpl) GetCertificate(ctx context.Context, host string) (*tls.Certificate, error) {
+	return nil, errors.Errorf("a certificate for %s is not available remotely", host)
+}
@@ -1,15 +1,21 @@
 package remote
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 
 	"github.com/srvc/ery/pkg/domain"
 )
@@ -19,13 +25,20 @@ func NewMappingRepository(url *url.URL, client *http.Client) domain.MappingRepos
 	m := &mappingRepositoryImpl{
 		baseURL: url,
 		client:  client,
+		log:     zap.L().Named("remote"),
 	}
 	return m
 }
 
+var (
+	minReconnectInterval = 500 * time.Millisecond
+	maxReconnectInterval = 30 * time.Second
+)
+
 type mappingRepositoryImpl struct {
 	baseURL *url.URL
 	client  *http.Client
+	log     *zap.Logger
 }
 
 func (m *mappingRepositoryImpl) List(ctx context.Context) ([]*domain.Mapping, error) {
@@ -56,22 +69,128 @@ func (m *mappingRepositoryImpl) List(ctx context.Context) ([]*domain.Mapping, er
 	return body.Mappings, nil
 }
 
+func (m *mappingRepositoryImpl) Get(ctx context.Context, host string) (*domain.Mapping, bool) {
+	req, err := http.NewRequest("GET", m.baseURL.String()+"/mappings/"+host, nil)
+	if err != nil {
+		return nil, false
+	}
+
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+
+	var mapping domain.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&mapping)
+	if err != nil {
+		return nil, false
+	}
+
+	return &mapping, true
+}
+
+type lookupResponse struct {
+	IP   net.IP `json:"ip"`
+	IPv6 net.IP `json:"ipv6,omitempty"`
+}
+
 func (m *mappingRepositoryImpl) LookupIP(ctx context.Context, host string) (net.IP, bool) {
-	panic("not implemented")
+	var resp lookupResponse
+	if ok, err := m.get(ctx, "/lookup/"+host, nil, &resp); !ok || err != nil {
+		return nil, false
+	}
+	return resp.IP, resp.IP != nil
+}
+
+func (m *mappingRepositoryImpl) LookupIPv6(ctx context.Context, host string) (net.IP, bool) {
+	var resp lookupResponse
+	if ok, err := m.get(ctx, "/lookup/"+host, nil, &resp); !ok || err != nil {
+		return nil, false
+	}
+	return resp.IPv6, resp.IPv6 != nil
+}
+
+func (m *mappingRepositoryImpl) LookupHost(ctx context.Context, ip net.IP) (string, bool) {
+	var resp struct {
+		Host string `json:"host"`
+	}
+	if ok, err := m.get(ctx, "/reverse/"+ip.String(), nil, &resp); !ok || err != nil {
+		return "", false
+	}
+	return resp.Host, resp.Host != ""
 }
 
 func (m *mappingRepositoryImpl) MapAddr(ctx context.Context, addr domain.Addr) (domain.Addr, error) {
-	panic("not implemented")
+	var rAddr domain.Addr
+	q := url.Values{}
+	q.Set("host", addr.Host)
+	q.Set("port", strconv.Itoa(int(addr.Port)))
+
+	ok, err := m.get(ctx, "/map", q, &rAddr)
+	if err != nil {
+		return domain.Addr{}, errors.WithStack(err)
+	}
+	if !ok {
+		return domain.Addr{}, errors.Errorf("%v is not found", addr)
+	}
+	return rAddr, nil
 }
 
-func (m *mappingRepositoryImpl) Create(ctx context.Context, addr domain.Addr, rPort domain.Port) (domain.Addr, error) {
+// get sends a GET request and decodes the response body into out.
+// It returns false without errors if the resource is not found.
+func (m *mappingRepositoryImpl) get(ctx context.Context, path string, query url.Values, out interface{}) (bool, error) {
+	u := m.baseURL.String() + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// ok
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, errors.Errorf("failed to get %s: %s", path, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
+
+func (m *mappingRepositoryImpl) Create(ctx context.Context, addr domain.Addr, rPort domain.Port, opts ...domain.CreateOption) (domain.Addr, error) {
 	var rAddr domain.Addr
 
 	if rPort != 0 {
 		return rAddr, errors.Errorf("cannot specify rPort: %v, %d", addr, rPort)
 	}
 
-	data, err := json.Marshal(addr)
+	data, err := json.Marshal(struct {
+		domain.Addr
+		domain.CreateOptions
+	}{
+		Addr:          addr,
+		CreateOptions: *domain.NewCreateOptions(opts...),
+	})
 	if err != nil {
 		return rAddr, errors.WithStack(err)
 	}
@@ -93,6 +212,106 @@ func (m *mappingRepositoryImpl) Create(ctx context.Context, addr domain.Addr, rP
 	return rAddr, errors.WithStack(err)
 }
 
+func (m *mappingRepositoryImpl) AddAlias(ctx context.Context, host, alias string) error {
+	data, err := json.Marshal(struct {
+		Alias string `json:"alias"`
+	}{Alias: alias})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req, err := http.NewRequest("POST", m.baseURL.String()+"/mappings/"+host+"/aliases", bytes.NewBuffer(data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return errors.Errorf("failed to add an alias %s to %s: %s", alias, host, resp.Status)
+	}
+
+	return nil
+}
+
+func (m *mappingRepositoryImpl) RenewLease(ctx context.Context, host string) error {
+	req, err := http.NewRequest("PUT", m.baseURL.String()+"/mappings/"+host+"/lease", nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return errors.Errorf("failed to renew a lease of %s: %s", host, resp.Status)
+	}
+
+	return nil
+}
+
+func (m *mappingRepositoryImpl) UpdateStatus(ctx context.Context, host string, status domain.MappingStatus) error {
+	data, err := json.Marshal(struct {
+		Status domain.MappingStatus `json:"status"`
+	}{Status: status})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req, err := http.NewRequest("PUT", m.baseURL.String()+"/mappings/"+host+"/status", bytes.NewBuffer(data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return errors.Errorf("failed to update a status of %s: %s", host, resp.Status)
+	}
+
+	return nil
+}
+
+func (m *mappingRepositoryImpl) UpdateRoutes(ctx context.Context, host string, routes domain.Routes) error {
+	data, err := json.Marshal(struct {
+		Routes domain.Routes `json:"routes"`
+	}{Routes: routes})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req, err := http.NewRequest("PUT", m.baseURL.String()+"/mappings/"+host+"/routes", bytes.NewBuffer(data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return errors.Errorf("failed to update routes of %s: %s", host, resp.Status)
+	}
+
+	return nil
+}
+
 func (m *mappingRepositoryImpl) DeleteByHost(ctx context.Context, host string) error {
 	req, err := http.NewRequest("DELETE", m.baseURL.String()+"/mappings/"+host, nil)
 	if err != nil {
@@ -103,9 +322,114 @@ func (m *mappingRepositoryImpl) DeleteByHost(ctx context.Context, host string) e
 	return errors.WithStack(err)
 }
 
+func (m *mappingRepositoryImpl) DeleteReplica(ctx context.Context, host, id string) error {
+	req, err := http.NewRequest("DELETE", m.baseURL.String()+"/mappings/"+host+"/replicas/"+url.PathEscape(id), nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return errors.Errorf("failed to delete a replica %s of %s: %s", id, host, resp.Status)
+	}
+
+	return nil
+}
+
+// ListenEvent subscribes the event stream of the API server.
+// It reconnects with exponential backoff when the connection is lost,
+// events occurred while disconnected are not delivered.
 func (m *mappingRepositoryImpl) ListenEvent(ctx context.Context) (<-chan domain.MappingEvent, <-chan error) {
 	evCh := make(chan domain.MappingEvent)
 	errCh := make(chan error, 1)
-	errCh <- errors.New("remote.MappingRepository.HasHost() has not been implemented yet")
+
+	go func() {
+		interval := minReconnectInterval
+		for {
+			connected, err := m.streamEvents(ctx, evCh)
+			if ctx.Err() != nil {
+				errCh <- errors.WithStack(ctx.Err())
+				return
+			}
+			if connected {
+				interval = minReconnectInterval
+			}
+			m.log.Debug("event stream is disconnected, reconnecting...", zap.Duration("interval", interval), zap.Error(err))
+
+			select {
+			case <-ctx.Done():
+				errCh <- errors.WithStack(ctx.Err())
+				return
+			case <-time.After(interval):
+			}
+
+			interval *= 2
+			if interval > maxReconnectInterval {
+				interval = maxReconnectInterval
+			}
+		}
+	}()
+
 	return evCh, errCh
 }
+
+// streamEvents reads server-sent events until the connection is closed.
+// connected reports whether the stream has been established.
+func (m *mappingRepositoryImpl) streamEvents(ctx context.Context, evCh chan<- domain.MappingEvent) (connected bool, err error) {
+	req, err := http.NewRequest("GET", m.baseURL.String()+"/mappings/events", nil)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.Errorf("failed to listen mapping events: %s", resp.Status)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	var data []string
+
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return true, errors.New("event stream is closed")
+		}
+		if err != nil {
+			return true, errors.WithStack(err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			// a blank line dispatches the event
+			if len(data) == 0 {
+				continue
+			}
+			var ev domain.MappingEvent
+			err = json.Unmarshal([]byte(strings.Join(data, "\n")), &ev)
+			data = data[:0]
+			if err != nil {
+				m.log.Warn("received an invalid mapping event", zap.Error(err))
+				continue
+			}
+			select {
+			case evCh <- ev:
+			case <-ctx.Done():
+				return true, errors.WithStack(ctx.Err())
+			}
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
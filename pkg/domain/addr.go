@@ -1,8 +1,9 @@
 package domain
 
 import (
-	"fmt"
+	"net"
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -49,10 +50,17 @@ func HTTPAddr(host string) Addr {
 }
 
 func (a *Addr) String() string {
-	return fmt.Sprintf("%s:%d", a.Host, a.Port)
+	return net.JoinHostPort(a.Host, strconv.FormatUint(uint64(a.Port), 10))
 }
 
 // IsValid returned true if the Addr object is valid.
 func (a *Addr) IsValid() bool {
 	return *a != Addr{}
 }
+
+// IsSubdomain returns true if the host is under the domain, e.g. "myapp.ery" is a subdomain of "ery".
+func IsSubdomain(host, domain string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	return domain != "" && strings.HasSuffix(host, "."+domain) && !strings.HasPrefix(host, ".")
+}
@@ -0,0 +1,20 @@
+package domain
+
+import (
+	"context"
+	"crypto/tls"
+)
+
+// CertificateRepository is an interface for accessing TLS certificates of virtual hosts.
+type CertificateRepository interface {
+	// GetCACertificate returns the PEM encoded root CA certificate that signs certificates of virtual hosts.
+	GetCACertificate(ctx context.Context) ([]byte, error)
+	// GetCertificate returns a certificate for the host, it is issued on demand.
+	GetCertificate(ctx context.Context, host string) (*tls.Certificate, error)
+}
+
+// IsCertifiable returns true if certificates can be issued for the host.
+// They are issued only for subdomains of the TLD, not to make the trusted CA sign certificates of real domains.
+func IsCertifiable(host, tld string) bool {
+	return IsSubdomain(host, tld)
+}
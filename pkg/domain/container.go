@@ -1,5 +1,12 @@
 package domain
 
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
 // Container contains meta data of a container.
 type Container struct {
 	ID           string
@@ -8,11 +15,39 @@ type Container struct {
 	Labels       map[string]string
 	Networks     []ContainerNetwork
 	PortBindings map[Port][]Port
+	HostIP       net.IP // an address that ports are published on, the local host is used if it is nil
+	ExposedPorts []Port
+	Protocols    map[Port]Protocol // transports of container ports other than TCP, such as UDP
+	Health       ContainerHealth
+	Paused       bool
 }
 
+// ContainerHealth represents a result of the health check of a container.
+type ContainerHealth int
+
+// Enum values of ContainerHealth.
+const (
+	// ContainerHealthNone means the container does not have a health check.
+	ContainerHealthNone ContainerHealth = iota
+	ContainerHealthStarting
+	ContainerHealthHealthy
+	ContainerHealthUnhealthy
+)
+
 // ContainerNetwork contains meta data of a container network.
 type ContainerNetwork struct {
 	Name string
+	IP   net.IP
+}
+
+// IP returns an address of the container on the first network that has it.
+func (c *Container) IP() (net.IP, bool) {
+	for _, n := range c.Networks {
+		if n.IP != nil {
+			return n.IP, true
+		}
+	}
+	return nil, false
 }
 
 // ContainerPlatform represents each container implementation, such as "docker".
@@ -22,11 +57,15 @@ type ContainerPlatform int
 const (
 	ContainerPlatformUnknown ContainerPlatform = iota
 	ContainerPlatformDocker
+	ContainerPlatformPodman
+	ContainerPlatformKubernetes
 )
 
 var (
 	nameByContainerPlatform = map[ContainerPlatform]string{
-		ContainerPlatformDocker: "docker",
+		ContainerPlatformDocker:     "docker",
+		ContainerPlatformPodman:     "podman",
+		ContainerPlatformKubernetes: "k8s",
 	}
 )
 
@@ -37,3 +76,42 @@ func (p ContainerPlatform) String() string {
 	}
 	return n
 }
+
+// ContainerPlatformFromString returns a ContainerPlatform having the name.
+func ContainerPlatformFromString(name string) (ContainerPlatform, error) {
+	for p, n := range nameByContainerPlatform {
+		if n == name {
+			return p, nil
+		}
+	}
+	return ContainerPlatformUnknown, errors.Errorf("unknown container platform: %q", name)
+}
+
+// ContainerEndpoint represents an API endpoint of a container runtime.
+type ContainerEndpoint struct {
+	Platform ContainerPlatform
+	// Host is an address of the API, such as "unix:///var/run/docker.sock", or a path of kubeconfig for Kubernetes.
+	// Environment variables (e.g. DOCKER_HOST, KUBECONFIG) are used if it is empty.
+	Host string
+}
+
+// ParseContainerEndpoint parses "<platform>[=<host>]", e.g. "podman=unix:///run/podman/podman.sock".
+func ParseContainerEndpoint(s string) (ContainerEndpoint, error) {
+	kv := strings.SplitN(s, "=", 2)
+	p, err := ContainerPlatformFromString(kv[0])
+	if err != nil {
+		return ContainerEndpoint{}, errors.WithStack(err)
+	}
+	e := ContainerEndpoint{Platform: p}
+	if len(kv) == 2 {
+		e.Host = kv[1]
+	}
+	return e, nil
+}
+
+func (e ContainerEndpoint) String() string {
+	if e.Host == "" {
+		return e.Platform.String()
+	}
+	return e.Platform.String() + "=" + e.Host
+}
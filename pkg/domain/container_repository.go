@@ -4,6 +4,7 @@ import "context"
 
 // ContainerRepository is an interface for accessing containers.
 type ContainerRepository interface {
+	List(context.Context) ([]Container, error)
 	ListenEvent(context.Context) (<-chan ContainerEvent, <-chan error)
 }
 
@@ -21,4 +22,11 @@ type ContainerEventType int
 const (
 	ContainerEventCreated ContainerEventType = iota
 	ContainerEventDestroyed
+	// ContainerEventHealthChanged is notified when a result of the health check is changed.
+	ContainerEventHealthChanged
+	// ContainerEventUpdated is notified when meta data of a running container is changed, e.g. renamed, paused, or connected to a network.
+	ContainerEventUpdated
+	// ContainerEventResync is notified before reconnecting to the container runtime, since containers may have been changed while disconnected.
+	// It has no containers, they should be listed again.
+	ContainerEventResync
 )
@@ -1,5 +1,11 @@
 package domain
 
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
 // PortMap is mapping of ports and addresses.
 type PortMap map[Port]Port
 
@@ -7,10 +13,166 @@ type PortMap map[Port]Port
 type Mapping struct {
 	VirtualHost string  `json:"virtual_host"`
 	ProxyHost   string  `json:"proxy_host"`
+	ProxyHostV6 string  `json:"proxy_host_v6,omitempty"`
 	PortMap     PortMap `json:"port_map"`
+
+	// TargetHost is a host that requests are proxied to, such as a container IP. It is the local host if empty.
+	TargetHost string `json:"target_host,omitempty"`
+
+	// Aliases are other hostnames sharing the proxy host and the port map.
+	Aliases []string `json:"aliases,omitempty"`
+
+	// Meta contains mapping metadata, such as an owner container ID.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// Lease is a lifetime of the mapping. The mapping never expires if it is nil.
+	Lease *Lease `json:"lease,omitempty"`
+
+	// Replicas are targets of replicated ports, requests are balanced across them.
+	// PortMap holds a port of the first replica.
+	Replicas map[Port][]Replica `json:"replicas,omitempty"`
+
+	// Protocols are protocols of ports configured explicitly. Other ports use DefaultProtocol.
+	Protocols map[Port]Protocol `json:"protocols,omitempty"`
+
+	// Status represents whether the target is ready to accept requests.
+	Status MappingStatus `json:"status,omitempty"`
+
+	// Routes forward HTTP requests to other targets by path prefixes.
+	Routes Routes `json:"routes,omitempty"`
 }
 
+// MappingStatus represents a readiness of the target of a mapping.
+type MappingStatus int
+
+// Enum values of MappingStatus.
+const (
+	MappingStatusReady MappingStatus = iota
+	// MappingStatusStarting means the target is booting, e.g. a container waiting for its health check.
+	MappingStatusStarting
+)
+
+var mappingStatusNames = map[MappingStatus]string{
+	MappingStatusReady:    "ready",
+	MappingStatusStarting: "starting",
+}
+
+func (s MappingStatus) String() string {
+	if n, ok := mappingStatusNames[s]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s MappingStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *MappingStatus) UnmarshalText(text []byte) error {
+	for v, n := range mappingStatusNames {
+		if n == string(text) {
+			*s = v
+			return nil
+		}
+	}
+	return errors.Errorf("unknown mapping status: %q", text)
+}
+
+// Replica represents one of targets sharing a port of the virtual host, such as a container of a scaled service.
+type Replica struct {
+	ID   string `json:"id"`
+	Host string `json:"host,omitempty"`
+	Port Port   `json:"port"`
+}
+
+// Lease represents a lifetime of a mapping that should be renewed by its owner periodically.
+type Lease struct {
+	TTL       time.Duration `json:"ttl"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// NewLease creates a new Lease object that expires after the ttl.
+func NewLease(ttl time.Duration) *Lease {
+	return &Lease{TTL: ttl, ExpiresAt: time.Now().Add(ttl)}
+}
+
+// Remaining returns a duration until the lease expires.
+func (l *Lease) Remaining() time.Duration {
+	return time.Until(l.ExpiresAt)
+}
+
+// Expired returns true if the lease has expired.
+func (l *Lease) Expired() bool {
+	return l.Remaining() <= 0
+}
+
+// Keys of Mapping.Meta.
+const (
+	MetaContainerID = "container_id"
+	MetaWorkingDir  = "working_dir"
+	MetaPID         = "pid"
+)
+
 // Map returns an Addr mapped on the given port.
 func (m *Mapping) Map(port Port) Addr {
-	return Addr{Port: m.PortMap[port]}
+	return Addr{Host: m.TargetHost, Port: m.PortMap[port]}
+}
+
+// Protocol returns a protocol that proxies relay connections of the port with.
+func (m *Mapping) Protocol(port Port) Protocol {
+	if p, ok := m.Protocols[port]; ok && p != ProtocolAuto {
+		return p
+	}
+	return DefaultProtocol(port)
+}
+
+// Clone returns a deep copy of the mapping.
+func (m *Mapping) Clone() *Mapping {
+	out := *m
+	out.PortMap = make(PortMap, len(m.PortMap))
+	for k, v := range m.PortMap {
+		out.PortMap[k] = v
+	}
+	out.Aliases = append([]string(nil), m.Aliases...)
+	if m.Meta != nil {
+		out.Meta = make(map[string]string, len(m.Meta))
+		for k, v := range m.Meta {
+			out.Meta[k] = v
+		}
+	}
+	if m.Lease != nil {
+		l := *m.Lease
+		out.Lease = &l
+	}
+	if m.Protocols != nil {
+		out.Protocols = make(map[Port]Protocol, len(m.Protocols))
+		for k, v := range m.Protocols {
+			out.Protocols[k] = v
+		}
+	}
+	if m.Routes != nil {
+		out.Routes = append(Routes(nil), m.Routes...)
+	}
+	if m.Replicas != nil {
+		out.Replicas = make(map[Port][]Replica, len(m.Replicas))
+		for k, v := range m.Replicas {
+			out.Replicas[k] = append([]Replica(nil), v...)
+		}
+	}
+	return &out
+}
+
+// ProxyAddrs returns addresses that proxy servers for the mapping should listen on.
+func (m *Mapping) ProxyAddrs() []Addr {
+	var addrs []Addr
+	for port := range m.PortMap {
+		for _, host := range []string{m.ProxyHost, m.ProxyHostV6} {
+			if host != "" {
+				addrs = append(addrs, Addr{Host: host, Port: port})
+			}
+		}
+	}
+	return addrs
 }
@@ -3,21 +3,32 @@ package domain
 import (
 	"context"
 	"net"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // MappingRepository is an interface for accessing <hostname>-<port> mappings.
 type MappingRepository interface {
 	List(ctx context.Context) ([]*Mapping, error)
+	Get(ctx context.Context, host string) (*Mapping, bool)
 	LookupIP(ctx context.Context, host string) (net.IP, bool)
+	LookupIPv6(ctx context.Context, host string) (net.IP, bool)
+	LookupHost(ctx context.Context, ip net.IP) (string, bool)
 	MapAddr(ctx context.Context, addr Addr) (Addr, error)
-	Create(ctx context.Context, lAddr Addr, rPort Port) (Addr, error)
+	Create(ctx context.Context, lAddr Addr, rPort Port, opts ...CreateOption) (Addr, error)
+	AddAlias(ctx context.Context, host, alias string) error
+	RenewLease(ctx context.Context, host string) error
+	UpdateStatus(ctx context.Context, host string, status MappingStatus) error
+	UpdateRoutes(ctx context.Context, host string, routes Routes) error
 	DeleteByHost(ctx context.Context, host string) error
+	DeleteReplica(ctx context.Context, host, id string) error
 	ListenEvent(ctx context.Context) (<-chan MappingEvent, <-chan error)
 }
 
 // MappingEvent contains event type and subject mapping.
 type MappingEvent struct {
-	Type MappingEventType
+	Type MappingEventType `json:"type"`
 	Mapping
 }
 
@@ -28,4 +39,119 @@ type MappingEventType int
 const (
 	MappingEventCreated MappingEventType = iota
 	MappingEventDestroyed
+	// MappingEventUpdated is notified when a mapping is changed without its ports, e.g. aliases, the status, routes or the lease.
+	MappingEventUpdated
 )
+
+var mappingEventTypeNames = map[MappingEventType]string{
+	MappingEventCreated:   "created",
+	MappingEventDestroyed: "destroyed",
+	MappingEventUpdated:   "updated",
+}
+
+func (t MappingEventType) String() string {
+	if s, ok := mappingEventTypeNames[t]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t MappingEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *MappingEventType) UnmarshalText(text []byte) error {
+	for v, s := range mappingEventTypeNames {
+		if s == string(text) {
+			*t = v
+			return nil
+		}
+	}
+	return errors.Errorf("unknown mapping event type: %q", text)
+}
+
+// CreateOptions contains optional parameters to create a mapping.
+type CreateOptions struct {
+	Meta       map[string]string `json:"meta,omitempty"`
+	LeaseTTL   time.Duration     `json:"lease_ttl,omitempty"`
+	ReplicaID  string            `json:"replica_id,omitempty"`
+	TargetHost string            `json:"target_host,omitempty"`
+	Status     MappingStatus     `json:"status,omitempty"`
+	Protocol   Protocol          `json:"protocol,omitempty"`
+	Routes     Routes            `json:"routes,omitempty"`
+}
+
+// CreateOption configures CreateOptions.
+type CreateOption func(*CreateOptions)
+
+// NewCreateOptions creates a CreateOptions object applied the given options.
+func NewCreateOptions(opts ...CreateOption) *CreateOptions {
+	o := new(CreateOptions)
+	for _, f := range opts {
+		f(o)
+	}
+	return o
+}
+
+// WithCreateOptions returns a CreateOption that replaces all options with the given one.
+// It is useful to relay options received from remote clients.
+func WithCreateOptions(in CreateOptions) CreateOption {
+	return func(o *CreateOptions) {
+		*o = in
+	}
+}
+
+// WithMeta returns a CreateOption that sets a metadata entry to the mapping.
+func WithMeta(key, value string) CreateOption {
+	return func(o *CreateOptions) {
+		if o.Meta == nil {
+			o.Meta = map[string]string{}
+		}
+		o.Meta[key] = value
+	}
+}
+
+// WithLease returns a CreateOption that makes the mapping expire unless it is renewed within the ttl.
+func WithLease(ttl time.Duration) CreateOption {
+	return func(o *CreateOptions) {
+		o.LeaseTTL = ttl
+	}
+}
+
+// WithReplica returns a CreateOption that registers the target as a replica.
+// Replicas can share a port of the virtual host, requests are balanced across them.
+func WithReplica(id string) CreateOption {
+	return func(o *CreateOptions) {
+		o.ReplicaID = id
+	}
+}
+
+// WithTargetHost returns a CreateOption that makes requests proxied to the host instead of the local host.
+func WithTargetHost(host string) CreateOption {
+	return func(o *CreateOptions) {
+		o.TargetHost = host
+	}
+}
+
+// WithStatus returns a CreateOption that sets a readiness of the target.
+func WithStatus(status MappingStatus) CreateOption {
+	return func(o *CreateOptions) {
+		o.Status = status
+	}
+}
+
+// WithProtocol returns a CreateOption that sets a protocol of the port.
+func WithProtocol(protocol Protocol) CreateOption {
+	return func(o *CreateOptions) {
+		o.Protocol = protocol
+	}
+}
+
+// WithRoutes returns a CreateOption that replaces routes of the mapping.
+func WithRoutes(routes Routes) CreateOption {
+	return func(o *CreateOptions) {
+		o.Routes = routes
+	}
+}
@@ -0,0 +1,113 @@
+package domain
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Protocol represents how proxies relay connections of a port, such as "http".
+type Protocol int
+
+// Enum values of Protocol.
+const (
+	// ProtocolAuto chooses a protocol by the port number, see DefaultProtocol.
+	ProtocolAuto Protocol = iota
+	// ProtocolHTTP routes requests by the Host header.
+	ProtocolHTTP
+	// ProtocolTCP splices TCP connections to the target as is.
+	ProtocolTCP
+	// ProtocolUDP relays datagrams between clients and the target.
+	ProtocolUDP
+)
+
+var protocolNames = map[Protocol]string{
+	ProtocolAuto: "auto",
+	ProtocolHTTP: "http",
+	ProtocolTCP:  "tcp",
+	ProtocolUDP:  "udp",
+}
+
+// tcpPorts are well-known ports of non-HTTP services, such as databases.
+var tcpPorts = map[Port]struct{}{
+	1433:  {}, // SQL Server
+	3306:  {}, // MySQL
+	5432:  {}, // PostgreSQL
+	5672:  {}, // AMQP
+	6379:  {}, // Redis
+	9042:  {}, // Cassandra
+	11211: {}, // Memcached
+	27017: {}, // MongoDB
+}
+
+// DefaultProtocol returns a protocol for the port that is not configured explicitly.
+// Well-known ports of databases use TCP, and others use HTTP.
+func DefaultProtocol(port Port) Protocol {
+	if _, ok := tcpPorts[port]; ok {
+		return ProtocolTCP
+	}
+	return ProtocolHTTP
+}
+
+// TransportPort is a container port published with a transport, such as "tcp" and "udp".
+type TransportPort struct {
+	Port      Port
+	Transport string
+}
+
+// RelayedPorts chooses ports to relay among published ones, and returns protocols of them.
+// Transports are case insensitive and an empty one means TCP. Unsupported transports, such as SCTP, are ignored.
+func RelayedPorts(ports []TransportPort) map[TransportPort]Protocol {
+	tcp := map[Port]bool{}
+	for _, p := range ports {
+		if t := strings.ToLower(p.Transport); t == "" || t == "tcp" {
+			tcp[p.Port] = true
+		}
+	}
+
+	relayed := make(map[TransportPort]Protocol, len(ports))
+	for _, p := range ports {
+		switch strings.ToLower(p.Transport) {
+		case "", "tcp":
+			relayed[p] = ProtocolAuto
+		case "udp":
+			// ports of both transports share a virtual port, so they are relayed as TCP
+			if !tcp[p.Port] {
+				relayed[p] = ProtocolUDP
+			}
+		}
+	}
+	return relayed
+}
+
+// ProtocolFromString returns a Protocol having the name.
+func ProtocolFromString(name string) (Protocol, error) {
+	for p, n := range protocolNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return ProtocolAuto, errors.Errorf("unknown protocol: %q", name)
+}
+
+func (p Protocol) String() string {
+	if n, ok := protocolNames[p]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p Protocol) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Protocol) UnmarshalText(text []byte) error {
+	v, err := ProtocolFromString(string(text))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	*p = v
+	return nil
+}
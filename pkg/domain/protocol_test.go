@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRelayedPorts(t *testing.T) {
+	cases := []struct {
+		test  string
+		ports []TransportPort
+		want  map[TransportPort]Protocol
+	}{
+		{
+			test:  "tcp",
+			ports: []TransportPort{{Port: 80, Transport: "tcp"}, {Port: 8080, Transport: ""}},
+			want:  map[TransportPort]Protocol{{Port: 80, Transport: "tcp"}: ProtocolAuto, {Port: 8080, Transport: ""}: ProtocolAuto},
+		},
+		{
+			test:  "udp",
+			ports: []TransportPort{{Port: 53, Transport: "UDP"}},
+			want:  map[TransportPort]Protocol{{Port: 53, Transport: "UDP"}: ProtocolUDP},
+		},
+		{
+			test: "mixed tcp and udp",
+			ports: []TransportPort{
+				{Port: 53, Transport: "udp"},
+				{Port: 53, Transport: "tcp"},
+				{Port: 5353, Transport: "udp"},
+			},
+			want: map[TransportPort]Protocol{{Port: 53, Transport: "tcp"}: ProtocolAuto, {Port: 5353, Transport: "udp"}: ProtocolUDP},
+		},
+		{
+			test:  "mixed tcp and udp in upper case",
+			ports: []TransportPort{{Port: 53, Transport: "UDP"}, {Port: 53, Transport: ""}},
+			want:  map[TransportPort]Protocol{{Port: 53, Transport: ""}: ProtocolAuto},
+		},
+		{
+			test:  "unsupported transport",
+			ports: []TransportPort{{Port: 9899, Transport: "sctp"}},
+			want:  map[TransportPort]Protocol{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.test, func(t *testing.T) {
+			if got := RelayedPorts(c.ports); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("RelayedPorts(%v) returned %v, want %v", c.ports, got, c.want)
+			}
+		})
+	}
+}
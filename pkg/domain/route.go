@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Route forwards HTTP requests having the path prefix to another target, such as "/api" to a backend server.
+type Route struct {
+	PathPrefix string `json:"path_prefix"`
+	// StripPrefix removes the path prefix from requests before proxying them.
+	StripPrefix bool `json:"strip_prefix,omitempty"`
+	// Target is a virtual address (e.g. "api.myapp.ery:8080"), or an address of a real host allowed by proxies (e.g. "localhost:3000").
+	// The virtual host of the mapping is used if the host is empty.
+	Target Addr `json:"target"`
+}
+
+// Routes is an ordered list of routes. The first route matching a path is used.
+type Routes []Route
+
+// NewRoute creates a Route object from a target such as "api.myapp.ery:8080", "api.myapp.ery" or ":8080".
+// The port is 80 if it is omitted.
+func NewRoute(pathPrefix, target string, stripPrefix bool) (Route, error) {
+	addr, err := parseRouteTarget(target)
+	if err != nil {
+		return Route{}, errors.WithStack(err)
+	}
+	r := Route{PathPrefix: pathPrefix, StripPrefix: stripPrefix, Target: addr}
+	if err := r.Validate(); err != nil {
+		return Route{}, errors.WithStack(err)
+	}
+	return r, nil
+}
+
+func parseRouteTarget(target string) (Addr, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		// without a port
+		return HTTPAddr(strings.Trim(target, "[]")), nil
+	}
+	p, err := PortFromString(port)
+	if err != nil {
+		return Addr{}, errors.Wrapf(err, "invalid route target %q", target)
+	}
+	return NewAddr(host, p), nil
+}
+
+// Validate returns an error if the route cannot be used.
+func (r *Route) Validate() error {
+	if !strings.HasPrefix(r.PathPrefix, "/") {
+		return errors.Errorf("path prefix should start with \"/\": %q", r.PathPrefix)
+	}
+	if r.Target.Port == 0 {
+		return errors.Errorf("target of %s should have a port", r.PathPrefix)
+	}
+	return nil
+}
+
+// Matches returns true if the path is the prefix itself or under it, e.g. "/api" matches "/api/users" but not "/apis".
+func (r *Route) Matches(path string) bool {
+	if !strings.HasPrefix(path, r.PathPrefix) {
+		return false
+	}
+	return strings.HasSuffix(r.PathPrefix, "/") || len(path) == len(r.PathPrefix) || path[len(r.PathPrefix)] == '/'
+}
+
+// Rewrite returns a path that the target receives.
+func (r *Route) Rewrite(path string) string {
+	if !r.StripPrefix {
+		return path
+	}
+	path = strings.TrimPrefix(path, strings.TrimSuffix(r.PathPrefix, "/"))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// Match returns the first route matching the path.
+func (rs Routes) Match(path string) (*Route, bool) {
+	for i := range rs {
+		if rs[i].Matches(path) {
+			return &rs[i], true
+		}
+	}
+	return nil, false
+}
+
+// Validate returns an error if any of the routes cannot be used.
+func (rs Routes) Validate() error {
+	for i := range rs {
+		if err := rs[i].Validate(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package domain
+
+import "testing"
+
+func TestNewRoute(t *testing.T) {
+	cases := []struct {
+		target string
+		want   Addr
+		err    bool
+	}{
+		{target: "api.myapp.ery:8080", want: Addr{Host: "api.myapp.ery", Port: 8080}},
+		{target: "api.myapp.ery", want: Addr{Host: "api.myapp.ery", Port: 80}},
+		{target: ":8080", want: Addr{Port: 8080}},
+		{target: "localhost:3000", want: Addr{Host: "localhost", Port: 3000}},
+		{target: "[::1]:3000", want: Addr{Host: "::1", Port: 3000}},
+		{target: "localhost:http", err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.target, func(t *testing.T) {
+			r, err := NewRoute("/api", c.target, false)
+			if c.err {
+				if err == nil {
+					t.Errorf("NewRoute should return an error for %q", c.target)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewRoute returned an error: %v", err)
+			}
+			if r.Target != c.want {
+				t.Errorf("target is %v, want %v", r.Target, c.want)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	cases := []struct {
+		prefix  string
+		path    string
+		matches bool
+		strip   string
+	}{
+		{prefix: "/api", path: "/api", matches: true, strip: "/"},
+		{prefix: "/api", path: "/api/users", matches: true, strip: "/users"},
+		{prefix: "/api", path: "/api/", matches: true, strip: "/"},
+		{prefix: "/api", path: "/apis"},
+		{prefix: "/api", path: "/"},
+		{prefix: "/api/", path: "/api/users", matches: true, strip: "/users"},
+		{prefix: "/api/", path: "/api"},
+		{prefix: "/", path: "/users", matches: true, strip: "/users"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.prefix+" "+c.path, func(t *testing.T) {
+			r := Route{PathPrefix: c.prefix}
+			if got := r.Matches(c.path); got != c.matches {
+				t.Errorf("Matches(%q) returned %t, want %t", c.path, got, c.matches)
+			}
+			if !c.matches {
+				return
+			}
+			if got := r.Rewrite(c.path); got != c.path {
+				t.Errorf("Rewrite(%q) without strip_prefix returned %q, want the path as is", c.path, got)
+			}
+			r.StripPrefix = true
+			if got := r.Rewrite(c.path); got != c.strip {
+				t.Errorf("Rewrite(%q) returned %q, want %q", c.path, got, c.strip)
+			}
+		})
+	}
+}
+
+func TestRoutes_Match(t *testing.T) {
+	rs := Routes{
+		{PathPrefix: "/api/admin", Target: Addr{Port: 8081}},
+		{PathPrefix: "/api", Target: Addr{Port: 8080}},
+	}
+
+	cases := []struct {
+		path string
+		port Port
+	}{
+		{path: "/api/admin/users", port: 8081},
+		{path: "/api/users", port: 8080},
+		{path: "/api/administrators", port: 8080},
+		{path: "/users"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			r, ok := rs.Match(c.path)
+			if c.port == 0 {
+				if ok {
+					t.Errorf("%q should not match any routes, but matched %s", c.path, r.PathPrefix)
+				}
+				return
+			}
+			if !ok || r.Target.Port != c.port {
+				t.Errorf("%q should match the route to the port %d", c.path, c.port)
+			}
+		})
+	}
+}
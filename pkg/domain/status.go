@@ -0,0 +1,11 @@
+package domain
+
+import "time"
+
+// Status represents a health of a component, such as a connection to a container platform.
+type Status struct {
+	Component string    `json:"component"`
+	Healthy   bool      `json:"healthy"`
+	Message   string    `json:"message,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
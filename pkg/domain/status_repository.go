@@ -0,0 +1,9 @@
+package domain
+
+import "context"
+
+// StatusRepository is an interface for accessing health statuses of components.
+type StatusRepository interface {
+	List(ctx context.Context) ([]*Status, error)
+	Set(ctx context.Context, status *Status) error
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/srvc/ery/pkg/ery"
+	"github.com/srvc/ery/pkg/ery/di"
+)
+
+func newCmdCert(cfg *ery.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "cert",
+		Short: "Print the CA certificate",
+		Long:  "Print the PEM encoded CA certificate that signs certificates of virtual hosts, it should be added to trust stores.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			app := di.NewClientApp(cfg)
+
+			data, err := app.CertRepo.GetCACertificate(context.Background())
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			_, err = cfg.OutWriter.Write(data)
+			return errors.WithStack(err)
+		},
+	}
+}
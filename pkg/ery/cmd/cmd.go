@@ -32,18 +32,28 @@ func NewEryCommand(cfg *ery.Config) *cobra.Command {
 	var (
 		dnsPort, apiPort uint16
 		apiHostname      string
+		dnsUpstreams     []string
+		dnsResolvConf    string
 	)
 
 	cliutil.AddLoggingFlags(cmd)
 	cmd.PersistentFlags().Uint16Var(&dnsPort, "dns-port", 53, "DNS server runs on the specified port")
 	cmd.PersistentFlags().Uint16Var(&apiPort, "api-port", 80, "API server runs on the specified port")
 	cmd.PersistentFlags().StringVar(&apiHostname, "api-host", "api.ery", "API server runs on the specified hostname")
+	cmd.PersistentFlags().StringSliceVar(&dnsUpstreams, "dns-upstream", nil, "DNS server forwards queries for unknown hosts to the specified resolvers")
+	cmd.PersistentFlags().StringVar(&dnsResolvConf, "dns-resolv-conf", "", "DNS server reads upstream resolvers from the specified resolv.conf")
 	cmd.Flags().SetInterspersed(false)
 
 	cobra.OnInitialize(func() {
 		cfg.DNS.Port = domain.Port(dnsPort)
+		cfg.DNS.TLD = cfg.TLD
+		cfg.DNS.Upstreams = dnsUpstreams
+		cfg.DNS.ResolvConf = dnsResolvConf
 		cfg.API.Port = domain.Port(apiPort)
 		cfg.API.Hostname = apiHostname
+		cfg.Container.TLD = cfg.TLD
+		cfg.Proxy.TLD = cfg.TLD
+		cfg.Container.LabelPrefix = cfg.Package
 	})
 
 	cmd.AddCommand(
@@ -51,6 +61,7 @@ func NewEryCommand(cfg *ery.Config) *cobra.Command {
 		newCmdDaemon(cfg),
 		newCmdStart(cfg),
 		newCmdPS(cfg),
+		newCmdCert(cfg),
 		newCmdVersion(cfg),
 	)
 
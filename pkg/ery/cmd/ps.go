@@ -4,24 +4,38 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+
+	"github.com/srvc/ery/pkg/domain"
 	"github.com/srvc/ery/pkg/ery"
 	"github.com/srvc/ery/pkg/ery/di"
 )
 
 func newCmdPS(cfg *ery.Config) *cobra.Command {
+	var watch bool
+
 	cmd := &cobra.Command{
 		Use: "ps",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
 			app := di.NewClientApp(cfg)
+			if watch {
+				return errors.WithStack(runPSWatchCommand(app, cfg.OutWriter))
+			}
 			return errors.WithStack(runPSCommand(app, cfg.OutWriter))
 		},
 	}
 
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "Watch for changes and print mappings again on every change")
+
 	return cmd
 }
 
@@ -33,15 +47,85 @@ func runPSCommand(app *di.ClientApp, w io.Writer) error {
 		return errors.WithStack(err)
 	}
 
+	return errors.WithStack(printMappings(w, mappings))
+}
+
+func runPSWatchCommand(app *di.ClientApp, w io.Writer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+
+	// subscribe before listing not to miss changes
+	evCh, errCh := app.MappingRepo.ListenEvent(ctx)
+
+	err := runPSCommand(app, w)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for {
+		select {
+		case <-evCh:
+			fmt.Fprintln(w)
+			err = runPSCommand(app, w)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		case err = <-errCh:
+			return errors.WithStack(err)
+		case <-sigCh:
+			return nil
+		}
+	}
+}
+
+func printMappings(w io.Writer, mappings []*domain.Mapping) error {
 	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
 
-	fmt.Fprintln(tw, "HOST\tPORT\tTARGET")
+	fmt.Fprintln(tw, "HOST\tPORT\tTARGET\tLEASE\tPID")
 
 	for _, m := range mappings {
+		host := m.ProxyHost
+		if m.TargetHost != "" {
+			host = m.TargetHost
+		}
 		for sPort, dPort := range m.PortMap {
-			fmt.Fprintf(tw, "%s\t%d\t%s:%d\n", m.VirtualHost, sPort, m.ProxyHost, dPort)
+			fmt.Fprintf(tw, "%s\t%d\t%s:%s\t%s\t%s\n", m.VirtualHost, sPort, host, formatTargetPorts(m, sPort, dPort), formatLease(m.Lease), formatMeta(m, domain.MetaPID))
 		}
 	}
 
 	return errors.WithStack(tw.Flush())
 }
+
+// formatTargetPorts returns a comma separated list of ports of replicas, or the port if the port is not replicated.
+func formatTargetPorts(m *domain.Mapping, sPort, dPort domain.Port) string {
+	replicas := m.Replicas[sPort]
+	if len(replicas) == 0 {
+		return strconv.Itoa(int(dPort))
+	}
+	ports := make([]string, 0, len(replicas))
+	for _, rep := range replicas {
+		ports = append(ports, strconv.Itoa(int(rep.Port)))
+	}
+	return strings.Join(ports, ",")
+}
+
+func formatLease(l *domain.Lease) string {
+	if l == nil {
+		return "-"
+	}
+	if l.Expired() {
+		return "expired"
+	}
+	return l.Remaining().Round(time.Second).String()
+}
+
+func formatMeta(m *domain.Mapping, key string) string {
+	if v, ok := m.Meta[key]; ok {
+		return v
+	}
+	return "-"
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"time"
 
 	"github.com/pkg/errors"
@@ -11,21 +12,40 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/srvc/ery/pkg/domain"
 	"github.com/srvc/ery/pkg/ery"
 	"github.com/srvc/ery/pkg/ery/di"
 )
 
 func newCmdStart(cfg *ery.Config) *cobra.Command {
+	var containerEndpoints []string
+
 	cmd := &cobra.Command{
 		Use:   "start",
 		Short: "Start ery server",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, s := range containerEndpoints {
+				e, err := domain.ParseContainerEndpoint(s)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				cfg.ContainerEndpoints = append(cfg.ContainerEndpoints, e)
+			}
+
 			cmd.SilenceUsage = true
 			app := di.NewServerApp(cfg)
 			return errors.WithStack(runStartCommand(app))
 		},
 	}
 
+	cmd.Flags().StringSliceVar(&containerEndpoints, "container-endpoint", []string{"docker"}, "Watch containers of the specified runtimes, e.g. docker, podman=unix:///run/podman/podman.sock, k8s=/path/to/kubeconfig")
+	cmd.Flags().BoolVar(&cfg.Container.RouteByIP, "container-ip", false, "Proxy requests to container IPs and exposed ports instead of published ports")
+	cmd.Flags().BoolVar(&cfg.Container.WaitHealthy, "container-wait-healthy", false, "Expose containers having health checks after they become healthy")
+	cmd.Flags().StringVar(&cfg.StateFile, "state-file", "", "Persist mappings into the specified file and restore them at startup")
+	cmd.Flags().BoolVar(&cfg.IPv6, "ipv6", false, "Listen on IPv6 loopback addresses and answer AAAA queries with them, they should be assigned to the loopback interface")
+	cmd.Flags().StringSliceVar(&cfg.Proxy.ExternalRouteHosts, "route-external-host", nil, "Allow routes to forward requests to the specified hosts outside ery, e.g. localhost")
+	cmd.Flags().StringVar(&cfg.CADir, "ca-dir", defaultCADir(), "Persist the local CA issuing certificates of virtual hosts into the specified directory")
+
 	return cmd
 }
 
@@ -69,3 +89,12 @@ func runStartCommand(app *di.ServerApp) error {
 
 	return err
 }
+
+// defaultCADir returns ~/.ery/ca, or an empty string if the home directory is unknown.
+func defaultCADir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ery", "ca")
+}
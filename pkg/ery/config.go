@@ -4,7 +4,10 @@ import (
 	"io"
 
 	"github.com/srvc/ery/pkg/app/api"
+	"github.com/srvc/ery/pkg/app/container"
 	"github.com/srvc/ery/pkg/app/dns"
+	"github.com/srvc/ery/pkg/app/proxy"
+	"github.com/srvc/ery/pkg/domain"
 )
 
 // Config is a configuration object.
@@ -20,6 +23,21 @@ type Config struct {
 	TLD     string
 	Package string
 
-	API api.Config
-	DNS dns.Config
+	// ContainerEndpoints are API endpoints of container runtimes to watch.
+	ContainerEndpoints []domain.ContainerEndpoint
+
+	// StateFile is a path to persist mappings across restarts. Mappings are not persisted if it is empty.
+	StateFile string
+
+	// CADir is a directory to persist the local CA that issues certificates of virtual hosts.
+	// The CA is not persisted if it is empty.
+	CADir string
+
+	// IPv6 makes proxies listen on IPv6 loopback addresses and the DNS server answer AAAA queries with them.
+	IPv6 bool
+
+	API       api.Config
+	DNS       dns.Config
+	Proxy     proxy.Config
+	Container container.Config
 }
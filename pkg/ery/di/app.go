@@ -20,6 +20,7 @@ type ServerApp struct {
 type ClientApp struct {
 	CommandRunner command.Runner
 	MappingRepo   domain.MappingRepository
+	CertRepo      domain.CertificateRepository
 }
 
 type DaemonApp struct {
@@ -35,6 +35,10 @@ func ProvideRemoteMappingRepository(url *url.URL, httpClient *http.Client) domai
 	return remote.NewMappingRepository(url, httpClient)
 }
 
+func ProvideRemoteCertificateRepository(url *url.URL, httpClient *http.Client) domain.CertificateRepository {
+	return remote.NewCertificateRepository(url, httpClient)
+}
+
 func ProvideAPIServerURL(cfg *api.Config) *url.URL {
 	return &url.URL{Scheme: "http", Host: fmt.Sprintf("%s:%d", cfg.Hostname, cfg.Port)}
 }
@@ -66,6 +70,7 @@ var ClientSet = wire.NewSet(
 	ClientApp{},
 	ProvideCommandRunner,
 	ProvideRemoteMappingRepository,
+	ProvideRemoteCertificateRepository,
 	ProvideAPIServerURL,
 	ProvideHTTPClient,
 )
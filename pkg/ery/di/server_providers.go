@@ -2,6 +2,7 @@ package di
 
 import (
 	"github.com/google/go-cloud/wire"
+	"github.com/spf13/afero"
 
 	"github.com/srvc/ery/pkg/app/api"
 	"github.com/srvc/ery/pkg/app/container"
@@ -15,24 +16,47 @@ import (
 func ProvideContainerWatcher(
 	cfg *ery.Config,
 	mappingRepo domain.MappingRepository,
-	containerRepo domain.ContainerRepository,
+	containerRepos []domain.ContainerRepository,
 ) container.Watcher {
 	return container.NewWatcher(
 		mappingRepo,
-		[]domain.ContainerRepository{
-			containerRepo,
-		},
-		cfg.TLD,
-		cfg.Package+".hostname",
+		containerRepos,
+		&cfg.Container,
 	)
 }
 
-func ProvideLocalMappingRepository() domain.MappingRepository {
-	return local.NewMappingRepository()
+func ProvideProxyConfig(cfg *ery.Config) *proxy.Config { return &cfg.Proxy }
+
+func ProvideLocalMappingRepository(cfg *ery.Config) domain.MappingRepository {
+	var store local.MappingStore
+	if cfg.StateFile != "" {
+		store = local.NewFileMappingStore(afero.NewOsFs(), cfg.StateFile)
+	}
+	return local.NewMappingRepository(store, cfg.IPv6)
+}
+
+func ProvideLocalCertificateRepository(cfg *ery.Config) domain.CertificateRepository {
+	if cfg.CADir == "" {
+		return local.NewCertificateRepository(afero.NewMemMapFs(), "/", cfg.TLD)
+	}
+	return local.NewCertificateRepository(afero.NewOsFs(), cfg.CADir, cfg.TLD)
 }
 
-func ProvideLocalDockerContainerRepository() domain.ContainerRepository {
-	return local.NewDockerContainerRepository()
+func ProvideLocalContainerRepositories(cfg *ery.Config, statusRepo domain.StatusRepository) []domain.ContainerRepository {
+	endpoints := cfg.ContainerEndpoints
+	if len(endpoints) == 0 {
+		endpoints = []domain.ContainerEndpoint{{Platform: domain.ContainerPlatformDocker}}
+	}
+	repos := make([]domain.ContainerRepository, 0, len(endpoints))
+	for _, e := range endpoints {
+		switch e.Platform {
+		case domain.ContainerPlatformKubernetes:
+			repos = append(repos, local.NewKubernetesContainerRepository(e, statusRepo))
+		default:
+			repos = append(repos, local.NewDockerContainerRepository(e, statusRepo))
+		}
+	}
+	return repos
 }
 
 var ServerSet = wire.NewSet(
@@ -42,7 +66,10 @@ var ServerSet = wire.NewSet(
 	dns.NewServer,
 	proxy.NewManager,
 	proxy.NewFactory,
+	ProvideProxyConfig,
 	ProvideContainerWatcher,
 	ProvideLocalMappingRepository,
-	ProvideLocalDockerContainerRepository,
+	ProvideLocalContainerRepositories,
+	ProvideLocalCertificateRepository,
+	local.NewStatusRepository,
 )
@@ -9,21 +9,25 @@ import (
 	"github.com/srvc/ery/pkg/app/api"
 	"github.com/srvc/ery/pkg/app/dns"
 	"github.com/srvc/ery/pkg/app/proxy"
+	"github.com/srvc/ery/pkg/data/local"
 	"github.com/srvc/ery/pkg/ery"
 )
 
 // Injectors from wire.go:
 
 func NewServerApp(cfg *ery.Config) *ServerApp {
-	mappingRepository := ProvideLocalMappingRepository()
+	mappingRepository := ProvideLocalMappingRepository(cfg)
+	statusRepository := local.NewStatusRepository()
+	certificateRepository := ProvideLocalCertificateRepository(cfg)
 	config := ProvideAPIConfig(cfg)
-	server := api.NewServer(mappingRepository, config)
+	server := api.NewServer(mappingRepository, statusRepository, certificateRepository, config)
 	dnsConfig := ProvideDNSConfig(cfg)
 	dnsServer := dns.NewServer(mappingRepository, dnsConfig)
-	serverFactory := proxy.NewFactory(mappingRepository)
+	proxyConfig := ProvideProxyConfig(cfg)
+	serverFactory := proxy.NewFactory(mappingRepository, certificateRepository, proxyConfig)
 	manager := proxy.NewManager(mappingRepository, serverFactory)
-	containerRepository := ProvideLocalDockerContainerRepository()
-	watcher := ProvideContainerWatcher(cfg, mappingRepository, containerRepository)
+	v := ProvideLocalContainerRepositories(cfg, statusRepository)
+	watcher := ProvideContainerWatcher(cfg, mappingRepository, v)
 	serverApp := &ServerApp{
 		APIServer:        server,
 		DNSServer:        dnsServer,
@@ -40,9 +44,11 @@ func NewClientApp(cfg *ery.Config) *ClientApp {
 	client := ProvideHTTPClient(dnsConfig)
 	mappingRepository := ProvideRemoteMappingRepository(url, client)
 	runner := ProvideCommandRunner(cfg, mappingRepository)
+	certificateRepository := ProvideRemoteCertificateRepository(url, client)
 	clientApp := &ClientApp{
 		CommandRunner: runner,
 		MappingRepo:   mappingRepository,
+		CertRepo:      certificateRepository,
 	}
 	return clientApp
 }
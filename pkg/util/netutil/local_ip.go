@@ -41,3 +41,27 @@ func RandomLoopbackAddr() net.IP {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	return net.IPv4(127, 0, 0, byte(1+r.Intn(255)))
 }
+
+// LoopbackPrefixV6 is an unique local address prefix that is routed to the loopback interface for virtual hosts.
+var LoopbackPrefixV6 = net.ParseIP("fd65:7279::")
+
+// LoopbackAddrV6 returns an IPv6 address paired with the given IPv4 loopback address.
+// The last byte of both addresses are same, e.g. 127.0.0.5 <-> fd65:7279::5.
+func LoopbackAddrV6(ip net.IP) net.IP {
+	v6 := make(net.IP, net.IPv6len)
+	copy(v6, LoopbackPrefixV6)
+	if v4 := ip.To4(); v4 != nil {
+		v6[net.IPv6len-1] = v4[net.IPv4len-1]
+	}
+	return v6
+}
+
+// LoopbackAddrV4 returns an IPv4 loopback address paired with the given IPv6 address.
+// It is an inverse function of LoopbackAddrV6.
+func LoopbackAddrV4(ip net.IP) (net.IP, bool) {
+	v6 := ip.To16()
+	if v6 == nil || ip.To4() != nil || !v6[:net.IPv6len-1].Equal(LoopbackPrefixV6[:net.IPv6len-1]) {
+		return nil, false
+	}
+	return net.IPv4(127, 0, 0, v6[net.IPv6len-1]), true
+}
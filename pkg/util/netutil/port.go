@@ -8,7 +8,7 @@ import (
 
 // GetFreePort find free open port that is ready to use.
 func GetFreePort(host string) (domain.Port, error) {
-	lis, err := net.Listen("tcp", host+":0")
+	lis, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,19 @@
+package procutil
+
+import (
+	"os"
+	"syscall"
+)
+
+// Alive returns true if a process with the given pid is running.
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}